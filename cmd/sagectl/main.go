@@ -2,31 +2,71 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/Arihantawasthi/sage.git/internal/config"
 	"github.com/Arihantawasthi/sage.git/internal/models"
 	"github.com/Arihantawasthi/sage.git/internal/spmp"
 	"github.com/Arihantawasthi/sage.git/internal/utils"
 )
 
 func main() {
-    if len(os.Args) < 2 {
-        fmt.Fprintf(os.Stderr, "Usage: sagectl [list|status|start|stop] <service-name>\n")
+    socket := flag.String("socket", os.Getenv("SAGE_SOCKET"), "daemon socket (env SAGE_SOCKET)")
+    flag.Usage = func() {
+        fmt.Fprintf(os.Stderr, "Usage: sagectl [--socket path] [list|status|start|stop|describe] <service-name>\n       sagectl [--socket path] reload [--restart-changed]\n       sagectl [--socket path] events [service-name]\n       sagectl [--socket path] logs <service-name> [-n N] [-f] [--stdout|--stderr] [--since 10m]\n       sagectl validate [path]\n")
+    }
+    flag.Parse()
+    args := flag.Args()
+    if len(args) < 1 {
+        flag.Usage()
         return
     }
 
-    command := os.Args[1]
-    serviceName := ""
-    if len(os.Args) < 3 && command != "list" {
-        fmt.Fprintf(os.Stderr, "Service name requried.\nExample Usage: sagectl %s <service-name>\n", command)
+    command := args[0]
+    if command == "validate" {
+        path := config.PathsFromEnv().Config
+        if len(args) > 1 {
+            path = args[1]
+        }
+        if !validate(path) {
+            os.Exit(1)
+        }
         return
     }
-    if command != "list" {
-        serviceName = os.Args[2]
+
+    if command == "logs" {
+        if !logs(*socket, args[1:]) {
+            os.Exit(1)
+        }
+        return
     }
 
-    client := spmp.NewSPMPClient()
+    serviceName := ""
+    switch command {
+    case "list":
+    case "events":
+        if len(args) > 1 {
+            serviceName = args[1]
+        }
+    case "reload":
+        if len(args) > 1 && args[1] == "--restart-changed" {
+            serviceName = spmp.ReloadRestartChanged
+        }
+    default:
+        if len(args) < 2 {
+            fmt.Fprintf(os.Stderr, "Service name requried.\nExample Usage: sagectl %s <service-name>\n", command)
+            return
+        }
+        serviceName = args[1]
+    }
+
+    client := spmp.NewSPMPClient(*socket)
     packet, err := buildPacket(command, serviceName)
     if err != nil {
         fmt.Fprintf(os.Stderr, "%v", err)
@@ -43,8 +83,36 @@ func main() {
         fmt.Fprintf(os.Stdout, "%s\n", receivedPkt.Payload)
         return
     }
+    if command == "list" {
+        printList(receivedPkt.Payload)
+        return
+    }
+    if command == "events" {
+        printEvents(receivedPkt.Payload)
+        return
+    }
+    if command == "status" {
+        printStatus(receivedPkt.Payload)
+        return
+    }
+    if command == "describe" {
+        printDescribe(receivedPkt.Payload)
+        return
+    }
+    if command == "reload" {
+        if !printReload(receivedPkt.Payload) {
+            os.Exit(1)
+        }
+        return
+    }
+    if !printResults(receivedPkt.Payload) {
+        os.Exit(1)
+    }
+}
+
+func printList(payload []byte) {
     var response models.Response[[]models.PListData]
-    json.Unmarshal(receivedPkt.Payload, &response)
+    json.Unmarshal(payload, &response)
     if response.RequestStatus == 0 {
         fmt.Fprintf(os.Stderr, "%s\n", response.Msg)
         return
@@ -54,7 +122,281 @@ func main() {
     if len(response.Data) > 0 {
         utils.PrintTable(response.Data)
     }
-    return
+}
+
+// printResults prints the per-service outcome of `start all`/`stop all`, or
+// why a start or stop failed as a whole, and reports whether every service
+// succeeded.
+func printResults(payload []byte) bool {
+    var response models.Response[[]models.ServiceResult]
+    if err := json.Unmarshal(payload, &response); err != nil {
+        fmt.Fprintf(os.Stderr, "error decoding response: %s\n", err)
+        return false
+    }
+    if response.RequestStatus == 0 && len(response.Data) == 0 {
+        fmt.Fprintf(os.Stderr, "%s\n", response.Msg)
+        return false
+    }
+
+    fmt.Fprintf(os.Stdout, "%s\n", response.Msg)
+    if len(response.Data) > 0 {
+        utils.PrintResults(response.Data)
+    }
+    return response.RequestStatus != 0
+}
+
+// printReload prints which services a reload added, removed and changed,
+// followed by what was done about them, and reports whether it succeeded.
+func printReload(payload []byte) bool {
+    var response models.Response[models.ReloadResult]
+    if err := json.Unmarshal(payload, &response); err != nil {
+        fmt.Fprintf(os.Stderr, "error decoding response: %s\n", err)
+        return false
+    }
+    if response.RequestStatus == 0 && len(response.Data.Results) == 0 {
+        fmt.Fprintf(os.Stderr, "%s\n", response.Msg)
+        return false
+    }
+
+    fmt.Fprintf(os.Stdout, "%s\n", response.Msg)
+    diff := response.Data.ConfigDiff
+    fmt.Fprintf(os.Stdout, "  added:   %s\n", listOrNone(diff.Added))
+    fmt.Fprintf(os.Stdout, "  removed: %s\n", listOrNone(diff.Removed))
+    fmt.Fprintf(os.Stdout, "  changed: %s\n", listOrNone(diff.Changed))
+    if len(response.Data.Results) > 0 {
+        utils.PrintResults(response.Data.Results)
+    }
+    return response.RequestStatus != 0
+}
+
+// validate checks a config file without involving the daemon. It defaults to
+// the file the daemon would load.
+func validate(path string) bool {
+    if path == "" {
+        var err error
+        path, err = config.ConfigPath()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%s\n", err)
+            return false
+        }
+    }
+
+    cfg, err := config.LoadFile(path)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", err)
+        return false
+    }
+    fmt.Fprintf(os.Stdout, "%s: OK (%d services)\n", path, len(cfg.ServiceMap))
+    return true
+}
+
+// logs prints the log of a service and, with -f, keeps printing new lines
+// until interrupted. Its flags may come before or after the service name.
+func logs(socket string, args []string) bool {
+    fs := flag.NewFlagSet("logs", flag.ExitOnError)
+    lines := fs.Int("n", 10, "number of lines to show, -1 for all (default all with --since)")
+    follow := fs.Bool("f", false, "keep printing lines as they are written")
+    stdout := fs.Bool("stdout", false, "only show stdout")
+    stderr := fs.Bool("stderr", false, "only show stderr")
+    since := fs.String("since", "", "only show lines from the last duration (e.g. 10m) or since an RFC3339 time")
+    fs.Usage = func() {
+        fmt.Fprintf(os.Stderr, "Usage: sagectl logs <service-name> [-n N] [-f] [--stdout|--stderr] [--since 10m]\n")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+    if fs.NArg() < 1 {
+        fs.Usage()
+        return false
+    }
+    req := models.LogsRequest{Service: fs.Arg(0)}
+    fs.Parse(fs.Args()[1:])
+    if fs.NArg() > 0 {
+        fs.Usage()
+        return false
+    }
+
+    req.Lines = *lines
+    req.Follow = *follow
+    switch {
+    case *stdout && *stderr:
+        fmt.Fprintf(os.Stderr, "only one of --stdout and --stderr can be given\n")
+        return false
+    case *stdout:
+        req.Stream = models.StreamStdout
+    case *stderr:
+        req.Stream = models.StreamStderr
+    }
+    if *since != "" {
+        if d, err := time.ParseDuration(*since); err == nil {
+            req.Since = time.Now().Add(-d)
+        } else if t, err := time.Parse(time.RFC3339, *since); err == nil {
+            req.Since = t
+        } else {
+            fmt.Fprintf(os.Stderr, "invalid --since '%s': expected a duration or an RFC3339 time\n", *since)
+            return false
+        }
+        linesSet := false
+        fs.Visit(func(f *flag.Flag) { linesSet = linesSet || f.Name == "n" })
+        if !linesSet {
+            req.Lines = -1
+        }
+    }
+
+    payload, err := json.Marshal(req)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "error encoding request: %s\n", err)
+        return false
+    }
+    packet, err := spmp.NewPacket(spmp.V1, spmp.JSONEncoding, spmp.TypeLogs, payload)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "error while creating packet: %s\n", err)
+        return false
+    }
+
+    ok := true
+    err = spmp.NewSPMPClient(socket).Stream(packet, func(pkt *spmp.Packet) error {
+        if string(pkt.Encoding[:]) == spmp.TEXTEncoding {
+            os.Stdout.Write(pkt.Payload)
+            return nil
+        }
+        var response models.Response[any]
+        if err := json.Unmarshal(pkt.Payload, &response); err != nil {
+            return fmt.Errorf("error decoding response: %s", err)
+        }
+        fmt.Fprintf(os.Stderr, "%s\n", response.Msg)
+        ok = false
+        return nil
+    })
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "error while fetching logs: %s\n", err)
+        return false
+    }
+    return ok
+}
+
+// printDescribe prints where a service is defined, its state and its full
+// definition.
+func printDescribe(payload []byte) {
+    var response models.Response[models.ServiceInfo]
+    if err := json.Unmarshal(payload, &response); err != nil {
+        fmt.Fprintf(os.Stderr, "error decoding response: %s\n", err)
+        return
+    }
+
+    info := response.Data
+    status := info.Status
+    if info.Pid != 0 {
+        status = fmt.Sprintf("%s (PID %d)", status, info.Pid)
+    }
+    if info.Outdated {
+        status += ", running an older definition"
+    }
+    fmt.Fprintf(os.Stdout, "Name:    %s\n", info.Service.Name)
+    fmt.Fprintf(os.Stdout, "Source:  %s\n", info.Source)
+    fmt.Fprintf(os.Stdout, "Status:  %s\n", status)
+    fmt.Fprintf(os.Stdout, "Command: %s\n", shellQuote(append([]string{info.Service.Command}, info.Service.Args...)))
+    if len(info.Service.Env) > 0 {
+        fmt.Fprintf(os.Stdout, "Env:     (in addition to the daemon's environment)\n")
+        keys := slices.Sorted(maps.Keys(info.Service.Env))
+        for _, k := range keys {
+            fmt.Fprintf(os.Stdout, "  %s=%s\n", k, info.Service.Env[k])
+        }
+    }
+    definition, err := json.MarshalIndent(info.Service, "", "  ")
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "error encoding definition: %s\n", err)
+        return
+    }
+    fmt.Fprintf(os.Stdout, "Definition:\n%s\n", definition)
+}
+
+func printEvents(payload []byte) {
+    var response models.Response[[]models.Event]
+    if err := json.Unmarshal(payload, &response); err != nil {
+        fmt.Fprintf(os.Stderr, "error decoding response: %s\n", err)
+        return
+    }
+    if len(response.Data) == 0 {
+        fmt.Fprintf(os.Stdout, "No events\n")
+        return
+    }
+    for _, e := range response.Data {
+        fmt.Fprintf(os.Stdout, "%s  %-12s %-10s %s\n", e.Time.Format(time.DateTime), e.Service, e.Type, e.Msg)
+    }
+}
+
+// printStatus prints the state of a service, its limits and what its cgroup
+// currently uses.
+func printStatus(payload []byte) {
+    var response models.Response[models.ServiceStatus]
+    if err := json.Unmarshal(payload, &response); err != nil {
+        fmt.Fprintf(os.Stderr, "error decoding response: %s\n", err)
+        return
+    }
+
+    st := response.Data
+    fmt.Fprintf(os.Stdout, "Name:    %s\n", st.Name)
+    if st.Pid != 0 {
+        fmt.Fprintf(os.Stdout, "Status:  %s (PID %d, up %s)\n", st.Status, st.Pid, st.UpTime)
+    } else {
+        fmt.Fprintf(os.Stdout, "Status:  %s\n", st.Status)
+    }
+
+    fmt.Fprintf(os.Stdout, "Limits:\n")
+    l := st.Limits
+    if l == nil {
+        l = &models.Limits{}
+    }
+    core := "-"
+    if l.Core != nil {
+        core = l.Core.String()
+    }
+    fmt.Fprintf(os.Stdout, "  nofile: %s  nproc: %s  core: %s\n", limitOrNone(l.NoFile), limitOrNone(l.NProc), core)
+    memory := "-"
+    if l.Memory > 0 {
+        memory = l.Memory.String()
+    }
+    fmt.Fprintf(os.Stdout, "  memory: %s  cpu: %s  pids: %s\n", memory, limitOrNone(l.CPU), limitOrNone(l.Pids))
+
+    if st.Usage == nil {
+        if l.NeedsCgroup() {
+            fmt.Fprintf(os.Stdout, "Cgroup:  none (cgroup limits are not enforced)\n")
+        }
+        return
+    }
+    u := st.Usage
+    fmt.Fprintf(os.Stdout, "Cgroup:  %s\n", st.Cgroup)
+    fmt.Fprintf(os.Stdout, "  memory: %s of %s (peak %s, %d OOM kills)\n", u.MemoryCurrent, u.MemoryMax, u.MemoryPeak, u.OOMKills)
+    fmt.Fprintf(os.Stdout, "  cpu:    %s used, max %s\n", u.CPUTime, u.CPUMax)
+    fmt.Fprintf(os.Stdout, "  pids:   %d of %s\n", u.PidsCurrent, u.PidsMax)
+}
+
+// limitOrNone prints a limit, or "-" when it is zero.
+func limitOrNone[T uint64 | int64 | float64](v T) string {
+    if v == 0 {
+        return "-"
+    }
+    return fmt.Sprint(v)
+}
+
+// shellQuote joins argv into a line that can be pasted into a shell.
+func shellQuote(argv []string) string {
+    quoted := make([]string, len(argv))
+    for i, arg := range argv {
+        if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`*?[]{}()<>|&;#~!") {
+            quoted[i] = arg
+            continue
+        }
+        quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+    }
+    return strings.Join(quoted, " ")
+}
+
+func listOrNone(names []string) string {
+    if len(names) == 0 {
+        return "-"
+    }
+    return strings.Join(names, ", ")
 }
 
 func buildPacket(cmd, serviceName string) (*spmp.Packet, error) {
@@ -68,6 +410,12 @@ func buildPacket(cmd, serviceName string) (*spmp.Packet, error) {
         msgType = spmp.TypeStop
     case "status":
         msgType = spmp.TypeStatus
+    case "reload":
+        msgType = spmp.TypeReload
+    case "describe":
+        msgType = spmp.TypeDescribe
+    case "events":
+        msgType = spmp.TypeEvents
     default:
         return nil, fmt.Errorf("unkown command: %s", cmd)
     }
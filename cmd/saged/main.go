@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -15,19 +16,44 @@ import (
 )
 
 func main() {
-    logger, err := logger.NewSlogLogger(models.LogFilePath)
+    if len(os.Args) > 1 && os.Args[1] == manager.HoldCommand {
+        manager.HoldPipes()
+        return
+    }
+
+    paths := config.PathsFromEnv()
+    flag.StringVar(&paths.Config, "config", paths.Config, "config file (env SAGE_CONFIG, default: the sage-conf.* file in ~/.sage)")
+    flag.StringVar(&paths.Socket, "socket", paths.Socket, "UNIX socket to listen on (env SAGE_SOCKET, default $XDG_RUNTIME_DIR/sage.sock or /tmp/sage.sock)")
+    flag.StringVar(&paths.StateDir, "state-dir", paths.StateDir, "directory for state.json (env SAGE_STATE_DIR, default ~/.sage)")
+    flag.StringVar(&paths.LogDir, "log-dir", paths.LogDir, "directory for service and daemon logs (env SAGE_LOG_DIR, default ~/.sage/logs)")
+    flag.Parse()
+
+    paths, err := paths.WithDefaults()
     if err != nil {
-		fmt.Fprintf(os.Stderr, "error while creating a logger: %s\n", err)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
     }
-	config, err := config.LoadConfig()
+	cfg, err := config.LoadConfig(paths.Config)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error reading config file: %s\n", err)
 		os.Exit(1)
 	}
+    log, err := logger.NewSlogLogger(paths.DaemonLog, cfg.DaemonLog)
+    if err != nil {
+		fmt.Fprintf(os.Stderr, "error while creating a logger: %s\n", err)
+		os.Exit(1)
+    }
+    log.Info("Starting daemon", "pid", os.Getpid())
 
-    processStore := manager.NewProcessStore(config)
-    spmpServer := spmp.NewSPMPServer(config, logger, processStore)
+    processStore := manager.NewProcessStore(cfg, paths, log)
+    adopted, err := processStore.RestoreState()
+    if err != nil {
+        log.Error("Failed to restore state", "err", err)
+    }
+    for _, name := range adopted {
+        log.Info("Re-adopted running service", "service", name)
+    }
+    spmpServer := spmp.NewSPMPServer(log, processStore, paths.Socket)
 
     ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
     defer stop()
@@ -39,5 +65,84 @@ func main() {
         }
     }()
 
+    go autostart(log, processStore)
+    go processStore.RunScheduler(ctx)
+    go reloadOnHangup(ctx, log, processStore)
+
     <-ctx.Done()
+    stop()
+    shutdown(log, spmpServer, processStore)
+}
+
+// reloadOnHangup reloads the config file every time the daemon gets SIGHUP.
+// Changed services keep running until they are restarted by hand.
+func reloadOnHangup(ctx context.Context, log *logger.SlogLogger, processStore *manager.ProcessStore) {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    defer signal.Stop(hup)
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-hup:
+        }
+
+        result, err := processStore.Reload(false)
+        if err != nil {
+            log.Error("Failed to reload config", "signal", "SIGHUP", "err", err)
+            continue
+        }
+        log.Configure(processStore.Config().DaemonLog)
+        log.Info("Reloaded config", "signal", "SIGHUP", "diff", result.ConfigDiff)
+        for _, r := range result.Results {
+            log.Info("Applied config change", "service", r.Name, "status", r.Status, "result", r.Msg)
+        }
+    }
+}
+
+// shutdown closes the SPMP server and, depending on the configured shutdown
+// mode, stops every running service or leaves them to be re-adopted.
+func shutdown(log logger.Logger, spmpServer *spmp.SPMPServer, processStore *manager.ProcessStore) {
+    cfg := processStore.Config()
+    log.Info("Shutting down", "mode", cfg.Shutdown)
+    if err := spmpServer.Shutdown(); err != nil {
+        log.Error("Failed to remove socket", "err", err)
+    }
+
+    if cfg.Shutdown != models.ShutdownStop {
+        log.Info("Leaving services running for re-adoption")
+        return
+    }
+    results, err := processStore.StopServices(config.ServiceNames(cfg.ServiceMap))
+    if err != nil {
+        log.Error("Failed to stop services", "err", err)
+        return
+    }
+    for _, r := range results {
+        if r.Status == models.ResultOK {
+            log.Info("Stopped service", "service", r.Name, "result", r.Msg)
+        }
+    }
+}
+
+// autostart starts every service marked with autostart, dependencies first.
+func autostart(log logger.Logger, processStore *manager.ProcessStore) {
+    names := config.AutostartNames(processStore.Config().ServiceMap)
+    if len(names) == 0 {
+        return
+    }
+
+    results, err := processStore.StartServices(names)
+    if err != nil {
+        log.Error("Failed to autostart services", "err", err)
+        return
+    }
+    for _, r := range results {
+        if r.Status == models.ResultOK {
+            log.Info("Autostarted service", "service", r.Name, "result", r.Msg)
+        } else {
+            log.Error("Failed to autostart service", "service", r.Name, "status", r.Status, "result", r.Msg)
+        }
+    }
 }
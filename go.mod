@@ -4,7 +4,12 @@ go 1.23.0
 
 toolchain go1.23.8
 
-require github.com/shirou/gopsutil v3.21.11+incompatible
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/shirou/gopsutil v3.21.11+incompatible
+	golang.org/x/sys v0.32.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/go-ole/go-ole v1.2.6 // indirect
@@ -12,5 +17,4 @@ require (
 	github.com/tklauser/go-sysconf v0.3.15 // indirect
 	github.com/tklauser/numcpus v0.10.0 // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
-	golang.org/x/sys v0.32.0 // indirect
 )
@@ -1,43 +1,157 @@
 package config
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/Arihantawasthi/sage.git/internal/models"
 )
 
-func LoadConfig() (models.Config, error) {
-    homeDir, err := os.UserHomeDir()
-    if err != nil {
-        return models.Config{}, fmt.Errorf("error locating home directory")
+// configFile is a single parsed config file. raw is the same file decoded
+// without a schema, used to find unknown fields.
+type configFile struct {
+	path     string
+	services models.Services
+	raw      map[string]any
+}
+
+// LoadConfig loads the config file at path, or the one in ~/.sage when path
+// is empty.
+func LoadConfig(path string) (models.Config, error) {
+    if path == "" {
+        var err error
+        path, err = ConfigPath()
+        if err != nil {
+            return models.Config{}, err
+        }
     }
+    return LoadFile(path)
+}
 
-    confFilePath := fmt.Sprintf("%s/.sage/sage-conf.json", homeDir)
-    _, err = os.Stat(confFilePath)
+// ConfigPath returns the config file in ~/.sage. Exactly one of the
+// supported file names has to exist.
+func ConfigPath() (string, error) {
+    homeDir, err := os.UserHomeDir()
     if err != nil {
-        os.OpenFile(confFilePath, os.O_CREATE, 0644)
-        return models.Config{}, nil
+        return "", fmt.Errorf("error locating home directory")
     }
+    dir := filepath.Join(homeDir, ".sage")
 
-	b, err := os.ReadFile(confFilePath)
-	if err != nil {
-		return models.Config{}, fmt.Errorf("error reading config file '%s': %w", confFilePath, err)
+	var found []string
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			found = append(found, path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("error reading config file '%s': %w", path, err)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no config file found, create one of %s in %s", strings.Join(configFileNames, ", "), dir)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("more than one config file found: %s", strings.Join(found, ", "))
 	}
+}
 
-	var services models.Services
-	err = json.Unmarshal(b, &services)
+// LoadFile loads the config file at path together with every file in the
+// conf.d directory next to it and the files matched by its include globs.
+// A service defined in a later file replaces one of the same name from an
+// earlier file: the main file comes first, then conf.d in lexical order, then
+// the includes in the order they are listed. Problems with the contents are
+// all reported at once in a *ValidationError.
+func LoadFile(confFilePath string) (models.Config, error) {
+	mainFile, err := readFile(confFilePath)
 	if err != nil {
-		return models.Config{}, fmt.Errorf("error unmarshalling config file '%s': %w", confFilePath, err)
+		return models.Config{}, err
 	}
 
-    m := make(map[string]models.Service)
-    for _, svc := range services.Services {
-        m[svc.Name] = svc
-    }
+	paths, problems := extraFiles(confFilePath, mainFile.services.Include)
+	files := []configFile{mainFile}
+	for _, path := range paths {
+		f, err := readFile(path)
+		if err != nil {
+			return models.Config{}, err
+		}
+		files = append(files, f)
+	}
+
+	m, sources, err := validate(files, problems)
+	if err != nil {
+		return models.Config{}, err
+	}
 
 	return models.Config{
-        ServiceMap: m,
+        ServiceMap:  m,
+        MaxParallel: mainFile.services.MaxParallel,
+        Shutdown:    mainFile.services.Shutdown,
+        DaemonLog:   mainFile.services.DaemonLog,
+        Sources:     sources,
     }, nil
 }
+
+func readFile(path string) (configFile, error) {
+	f := configFile{path: path, raw: make(map[string]any)}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return f, fmt.Errorf("error reading config file '%s': %w", path, err)
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return f, nil
+	}
+	if err := decodeFile(path, b, &f.services); err != nil {
+		return f, fmt.Errorf("error parsing config file: %w", err)
+	}
+	if err := decodeFile(path, b, &f.raw); err != nil {
+		return f, fmt.Errorf("error parsing config file: %w", err)
+	}
+	return f, nil
+}
+
+// extraFiles lists the config files in the conf.d directory next to the main
+// file, in lexical order, followed by the matches of every include glob.
+// Relative globs are taken from the directory of the main file, a glob may
+// match nothing, and a file is only read once, at its first position.
+func extraFiles(mainPath string, includes []string) ([]string, []string) {
+	dir := filepath.Dir(mainPath)
+	seen := map[string]bool{filepath.Clean(mainPath): true}
+	var files, problems []string
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "conf.d"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		problems = append(problems, fmt.Sprintf("conf.d: %v", err))
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && slices.Contains(configExts, strings.ToLower(filepath.Ext(entry.Name()))) {
+			add(filepath.Join(dir, "conf.d", entry.Name()))
+		}
+	}
+
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("include '%s': %v", pattern, err))
+			continue
+		}
+		for _, path := range matches {
+			add(filepath.Clean(path))
+		}
+	}
+	return files, problems
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+// LookupCredential resolves the user and group a service runs as. The group
+// defaults to the user's primary group, and the user's supplementary groups
+// are kept. Both nil when the service sets neither; usr is nil when it only
+// sets a group.
+func LookupCredential(service models.Service) (*syscall.Credential, *user.User, error) {
+	if service.User == "" && service.Group == "" {
+		return nil, nil, nil
+	}
+
+	cred := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	var usr *user.User
+	if service.User != "" {
+		var err error
+		usr, err = lookupUser(service.User)
+		if err != nil {
+			return nil, nil, err
+		}
+		cred.Uid, err = parseID(usr.Uid)
+		if err != nil {
+			return nil, nil, err
+		}
+		cred.Gid, err = parseID(usr.Gid)
+		if err != nil {
+			return nil, nil, err
+		}
+		groupIDs, err := usr.GroupIds()
+		if err != nil {
+			return nil, nil, fmt.Errorf("groups of user '%s': %w", usr.Username, err)
+		}
+		for _, id := range groupIDs {
+			gid, err := parseID(id)
+			if err != nil {
+				return nil, nil, err
+			}
+			cred.Groups = append(cred.Groups, gid)
+		}
+	}
+
+	if service.Group != "" {
+		grp, err := lookupGroup(service.Group)
+		if err != nil {
+			return nil, nil, err
+		}
+		cred.Gid, err = parseID(grp.Gid)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return cred, usr, nil
+}
+
+// lookupUser accepts a user name or a numeric UID.
+func lookupUser(name string) (*user.User, error) {
+	usr, err := user.Lookup(name)
+	if _, numErr := strconv.Atoi(name); err != nil && numErr == nil {
+		usr, err = user.LookupId(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("user '%s' does not exist", name)
+	}
+	return usr, nil
+}
+
+// lookupGroup accepts a group name or a numeric GID.
+func lookupGroup(name string) (*user.Group, error) {
+	grp, err := user.LookupGroup(name)
+	if _, numErr := strconv.Atoi(name); err != nil && numErr == nil {
+		grp, err = user.LookupGroupId(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("group '%s' does not exist", name)
+	}
+	return grp, nil
+}
+
+func parseID(id string) (uint32, error) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id '%s'", id)
+	}
+	return uint32(n), nil
+}
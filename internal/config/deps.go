@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+// StartOrder returns names together with everything they transitively
+// depend on, ordered so that every service comes after its dependencies.
+func StartOrder(services map[string]models.Service, names []string) ([]string, error) {
+	var order []string
+	var path []string
+	state := make(map[string]int)
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			cycle := append(path[slices.Index(path, name):], name)
+			return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		svc, exists := services[name]
+		if !exists {
+			return fmt.Errorf("unknown service '%s'", name)
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range svc.DependsOn {
+			if _, exists := services[dep]; !exists {
+				return fmt.Errorf("service '%s' depends on unknown service '%s'", name, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// StopOrder returns names together with every service that transitively
+// depends on them, ordered so that dependents are stopped first.
+func StopOrder(services map[string]models.Service, names []string) ([]string, error) {
+	dependents := make(map[string][]string)
+	for name, svc := range services {
+		for _, dep := range svc.DependsOn {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	closure := make(map[string]bool)
+	queue := slices.Clone(names)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if closure[name] {
+			continue
+		}
+		closure[name] = true
+		queue = append(queue, dependents[name]...)
+	}
+
+	members := make([]string, 0, len(closure))
+	for name := range closure {
+		members = append(members, name)
+	}
+	slices.Sort(members)
+
+	order, err := StartOrder(services, members)
+	if err != nil {
+		return nil, err
+	}
+	order = slices.DeleteFunc(order, func(name string) bool { return !closure[name] })
+	slices.Reverse(order)
+	return order, nil
+}
+
+// ServiceNames returns the names in the service map in sorted order.
+func ServiceNames(services map[string]models.Service) []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// AutostartNames returns the sorted names of services marked with autostart.
+func AutostartNames(services map[string]models.Service) []string {
+	var names []string
+	for _, name := range ServiceNames(services) {
+		if services[name].Autostart {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// UnscheduledNames returns the sorted names of services without a schedule,
+// which are the ones `start all` starts.
+func UnscheduledNames(services map[string]models.Service) []string {
+	var names []string
+	for _, name := range ServiceNames(services) {
+		if services[name].Schedule == "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
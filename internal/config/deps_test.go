@@ -0,0 +1,173 @@
+package config
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+// graph builds a service map from names to their dependencies.
+func graph(deps map[string][]string) map[string]models.Service {
+	services := make(map[string]models.Service)
+	for name, dependsOn := range deps {
+		services[name] = models.Service{Name: name, DependsOn: dependsOn}
+	}
+	return services
+}
+
+func TestStartOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		deps  map[string][]string
+		names []string
+		want  []string
+		err   string
+	}{
+		{
+			name:  "no dependencies",
+			deps:  map[string][]string{"a": nil, "b": nil},
+			names: []string{"b", "a"},
+			want:  []string{"b", "a"},
+		},
+		{
+			name:  "chain",
+			deps:  map[string][]string{"web": {"api"}, "api": {"db"}, "db": nil},
+			names: []string{"web"},
+			want:  []string{"db", "api", "web"},
+		},
+		{
+			name:  "diamond",
+			deps:  map[string][]string{"web": {"api", "cache"}, "api": {"db"}, "cache": {"db"}, "db": nil},
+			names: []string{"web"},
+			want:  []string{"db", "api", "cache", "web"},
+		},
+		{
+			name:  "shared dependency listed once",
+			deps:  map[string][]string{"a": {"db"}, "b": {"db"}, "db": nil},
+			names: []string{"a", "b"},
+			want:  []string{"db", "a", "b"},
+		},
+		{
+			name:  "dependency asked for after its dependent",
+			deps:  map[string][]string{"web": {"db"}, "db": nil},
+			names: []string{"web", "db"},
+			want:  []string{"db", "web"},
+		},
+		{
+			name:  "self",
+			deps:  map[string][]string{"a": {"a"}},
+			names: []string{"a"},
+			err:   "dependency cycle: a -> a",
+		},
+		{
+			name:  "cycle",
+			deps:  map[string][]string{"web": {"a"}, "a": {"b"}, "b": {"c"}, "c": {"a"}},
+			names: []string{"web"},
+			err:   "dependency cycle: a -> b -> c -> a",
+		},
+		{
+			name:  "unknown service",
+			deps:  map[string][]string{"a": nil},
+			names: []string{"b"},
+			err:   "unknown service 'b'",
+		},
+		{
+			name:  "unknown dependency",
+			deps:  map[string][]string{"web": {"db"}},
+			names: []string{"web"},
+			err:   "service 'web' depends on unknown service 'db'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StartOrder(graph(tt.deps), tt.names)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("StartOrder() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StartOrder() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("StartOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStopOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		deps  map[string][]string
+		names []string
+		want  []string
+		err   string
+	}{
+		{
+			name:  "no dependents",
+			deps:  map[string][]string{"web": {"db"}, "db": nil},
+			names: []string{"web"},
+			want:  []string{"web"},
+		},
+		{
+			name:  "dependents first",
+			deps:  map[string][]string{"web": {"api"}, "api": {"db"}, "db": nil, "other": nil},
+			names: []string{"db"},
+			want:  []string{"web", "api", "db"},
+		},
+		{
+			name:  "diamond",
+			deps:  map[string][]string{"web": {"api", "cache"}, "api": {"db"}, "cache": {"db"}, "db": nil},
+			names: []string{"db"},
+			want:  []string{"web", "cache", "api", "db"},
+		},
+		{
+			name:  "cycle",
+			deps:  map[string][]string{"a": {"b"}, "b": {"a"}},
+			names: []string{"a"},
+			err:   "dependency cycle: a -> b -> a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StopOrder(graph(tt.deps), tt.names)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("StopOrder() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StopOrder() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("StopOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutostartNames(t *testing.T) {
+	services := map[string]models.Service{
+		"c": {Name: "c", Autostart: true},
+		"b": {Name: "b"},
+		"a": {Name: "a", Autostart: true},
+	}
+	if got, want := AutostartNames(services), []string{"a", "c"}; !slices.Equal(got, want) {
+		t.Errorf("AutostartNames() = %v, want %v", got, want)
+	}
+}
+
+func TestUnscheduledNames(t *testing.T) {
+	services := map[string]models.Service{
+		"web":    {Name: "web"},
+		"backup": {Name: "backup", Schedule: "@daily"},
+		"api":    {Name: "api"},
+	}
+	if got, want := UnscheduledNames(services), []string{"api", "web"}; !slices.Equal(got, want) {
+		t.Errorf("UnscheduledNames() = %v, want %v", got, want)
+	}
+}
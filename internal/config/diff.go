@@ -0,0 +1,27 @@
+package config
+
+import (
+	"reflect"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+// Diff compares the services of two configs by name. A service is changed
+// when any part of its definition differs.
+func Diff(oldCfg, newCfg models.Config) models.ConfigDiff {
+	var diff models.ConfigDiff
+	for _, name := range ServiceNames(newCfg.ServiceMap) {
+		oldSvc, exists := oldCfg.ServiceMap[name]
+		if !exists {
+			diff.Added = append(diff.Added, name)
+		} else if !reflect.DeepEqual(oldSvc, newCfg.ServiceMap[name]) {
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for _, name := range ServiceNames(oldCfg.ServiceMap) {
+		if _, exists := newCfg.ServiceMap[name]; !exists {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	return diff
+}
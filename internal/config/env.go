@@ -0,0 +1,355 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+// applyDefaults fills every field svc leaves unset with the one from
+// defaults. A field is unset when its key isn't in raw, the service as it was
+// written in its file, so an explicit false or 0 is kept. Nested structs are
+// filled field by field and env maps are merged, with the service's own keys
+// winning. tag is the struct tag of the file's format.
+func applyDefaults(svc, defaults models.Service, raw any, tag string) models.Service {
+	mergeDefaults(reflect.ValueOf(&svc).Elem(), reflect.ValueOf(defaults), raw, tag)
+	return svc
+}
+
+func mergeDefaults(dst, src reflect.Value, raw any, tag string) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if f.Anonymous && key == "" && f.Type.Kind() == reflect.Struct {
+			mergeDefaults(dst.Field(i), src.Field(i), raw, tag)
+			continue
+		}
+		if !f.IsExported() || key == "-" {
+			continue
+		}
+		if key == "" {
+			key = f.Name
+		}
+
+		value, set := rawField(raw, key, tag)
+		df, sf := dst.Field(i), src.Field(i)
+		switch {
+		case !set:
+			df.Set(sf)
+		case df.Kind() == reflect.Pointer && df.Elem().Kind() == reflect.Struct && !sf.IsNil():
+			if df.IsNil() {
+				// Written as null.
+				df.Set(sf)
+			} else {
+				mergeDefaults(df.Elem(), sf.Elem(), value, tag)
+			}
+		case df.Kind() == reflect.Struct && df.Type() != reflect.TypeOf(models.Duration{}):
+			mergeDefaults(df, sf, value, tag)
+		case df.Kind() == reflect.Map && !sf.IsNil():
+			if df.IsNil() {
+				df.Set(reflect.MakeMap(df.Type()))
+			}
+			iter := sf.MapRange()
+			for iter.Next() {
+				if !df.MapIndex(iter.Key()).IsValid() {
+					df.SetMapIndex(iter.Key(), iter.Value())
+				}
+			}
+		}
+	}
+}
+
+// rawField looks key up in a schemaless decoding of a struct the way the
+// decoder for tag does.
+func rawField(raw any, key, tag string) (any, bool) {
+	v := reflect.ValueOf(raw)
+	if v.Kind() != reflect.Map {
+		return nil, false
+	}
+	iter := v.MapRange()
+	for iter.Next() {
+		name := fmt.Sprint(iter.Key().Interface())
+		// encoding/json matches keys case-insensitively.
+		if name == key || tag == "json" && strings.EqualFold(name, key) {
+			return iter.Value().Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// rawIndex is the i-th element of a schemaless decoding of a list.
+func rawIndex(raw any, i int) any {
+	v := reflect.ValueOf(raw)
+	if v.Kind() != reflect.Slice || i >= v.Len() {
+		return nil
+	}
+	return v.Index(i).Interface()
+}
+
+// resolveService loads the service's env files and expands every ${VAR} in
+// its env values, command, args, working directory and probes. Variables are
+// looked up in the service's env, then its env files, then the daemon's
+// environment; a value that refers to its own name gets the one from the
+// layer below. Relative env files are taken from dir. $${ stands for a
+// literal ${.
+func resolveService(svc models.Service, dir string) (models.Service, error) {
+	layers := []map[string]string{environ()}
+	for _, path := range svc.EnvFile {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		vars, err := parseEnvFile(path)
+		if err != nil {
+			return svc, err
+		}
+		layers = append(layers, vars)
+	}
+	layers = append(layers, svc.Env)
+
+	r := &envResolver{layers: layers, visiting: make(map[string]bool)}
+	env := make(map[string]string)
+	for _, layer := range layers[1:] {
+		for _, key := range slices.Sorted(maps.Keys(layer)) {
+			if _, done := env[key]; done {
+				continue
+			}
+			v, err := r.resolve(key, len(layers))
+			if err != nil {
+				return svc, err
+			}
+			env[key] = v
+		}
+	}
+
+	lookup := func(name string) (string, bool) {
+		if v, exists := env[name]; exists {
+			return v, true
+		}
+		v, exists := layers[0][name]
+		return v, exists
+	}
+	var err error
+	expandField := func(s string) string {
+		if err != nil {
+			return s
+		}
+		var out string
+		out, err = expand(s, lookup)
+		return out
+	}
+
+	svc.Env = env
+	svc.Command = expandField(svc.Command)
+	svc.Args = expandAll(svc.Args, expandField)
+	svc.WorkingDir = expandField(svc.WorkingDir)
+	if svc.Readiness != nil {
+		p := expandProbe(*svc.Readiness, expandField)
+		svc.Readiness = &p
+	}
+	if svc.Liveness != nil {
+		lp := *svc.Liveness
+		lp.Probe = expandProbe(lp.Probe, expandField)
+		svc.Liveness = &lp
+	}
+	return svc, err
+}
+
+func expandProbe(p models.Probe, expandField func(string) string) models.Probe {
+	p.Address = expandField(p.Address)
+	p.URL = expandField(p.URL)
+	p.Command = expandAll(p.Command, expandField)
+	return p
+}
+
+func expandAll(list []string, expandField func(string) string) []string {
+	if list == nil {
+		return nil
+	}
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = expandField(s)
+	}
+	return out
+}
+
+// envResolver expands env values that refer to each other, across layers
+// ordered from the daemon environment up to the service's own env.
+type envResolver struct {
+	layers   []map[string]string
+	visiting map[string]bool
+}
+
+// resolve returns the expanded value of name as seen from the layers below
+// the given one.
+func (r *envResolver) resolve(name string, below int) (string, error) {
+	layer := -1
+	for i := below - 1; i >= 0; i-- {
+		if _, exists := r.layers[i][name]; exists {
+			layer = i
+			break
+		}
+	}
+	if layer < 0 {
+		return "", fmt.Errorf("undefined variable '%s'", name)
+	}
+	value := r.layers[layer][name]
+	if layer == 0 {
+		return value, nil
+	}
+
+	key := fmt.Sprintf("%d/%s", layer, name)
+	if r.visiting[key] {
+		return "", fmt.Errorf("variable '%s' is part of a reference cycle", name)
+	}
+	r.visiting[key] = true
+	defer delete(r.visiting, key)
+
+	var refErr error
+	v, err := expand(value, func(ref string) (string, bool) {
+		from := len(r.layers)
+		if ref == name {
+			from = layer
+		}
+		v, err := r.resolve(ref, from)
+		if err != nil && refErr == nil {
+			refErr = err
+		}
+		return v, err == nil
+	})
+	if refErr != nil {
+		return "", refErr
+	}
+	return v, err
+}
+
+// expand replaces every ${NAME} in s. Other uses of $ are left alone, so
+// shell snippets in args keep working.
+func expand(s string, lookup func(string) (string, bool)) (string, error) {
+	var sb strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			sb.WriteString(s)
+			return sb.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			sb.WriteString(s[:i])
+			sb.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated '${' in '%s'", s)
+		}
+		name := s[i+2 : i+end]
+		if !envKey.MatchString(name) {
+			return "", fmt.Errorf("invalid variable name '%s'", name)
+		}
+		v, exists := lookup(name)
+		if !exists {
+			return "", fmt.Errorf("undefined variable '%s'", name)
+		}
+		sb.WriteString(s[:i])
+		sb.WriteString(v)
+		s = s[i+end+1:]
+	}
+}
+
+func environ() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// parseEnvFile reads a dotenv file: KEY=VALUE lines with an optional
+// "export " prefix, blank lines and # comments. Double-quoted values may use
+// \n, \t, \" and \\; single-quoted values are taken literally and are not
+// expanded.
+func parseEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("env file '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKey.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNum)
+		}
+		value, err := envFileValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		vars[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("env file '%s': %w", path, err)
+	}
+	return vars, nil
+}
+
+func envFileValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quote")
+		}
+		// Keep expand from touching it.
+		return strings.ReplaceAll(value[1:end+1], "${", "$${"), nil
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(value); i++ {
+			c := value[i]
+			switch {
+			case c == '"':
+				return sb.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				default:
+					sb.WriteByte(value[i])
+				}
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated double quote")
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		return value, nil
+	}
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"HOST": "localhost", "PORT": "8080", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, exists := vars[name]
+		return v, exists
+	}
+	tests := []struct {
+		in   string
+		want string
+		err  string
+	}{
+		{in: "plain", want: "plain"},
+		{in: "${HOST}:${PORT}", want: "localhost:8080"},
+		{in: "a${EMPTY}b", want: "ab"},
+		{in: "$HOST $(date) $1", want: "$HOST $(date) $1"},
+		{in: "$${HOST}", want: "${HOST}"},
+		{in: "$${HOST} ${HOST}", want: "${HOST} localhost"},
+		{in: "${HOST", err: "unterminated '${' in '${HOST'"},
+		{in: "${1X}", err: "invalid variable name '1X'"},
+		{in: "${MISSING}", err: "undefined variable 'MISSING'"},
+	}
+	for _, tt := range tests {
+		got, err := expand(tt.in, lookup)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expand(%q) error = %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolveService(t *testing.T) {
+	t.Setenv("SAGE_TEST_HOME", "/home/sage")
+	t.Setenv("SAGE_TEST_PATH", "/usr/bin")
+
+	dir := t.TempDir()
+	envFile := "FROM_FILE=file\nSAGE_TEST_PATH=${SAGE_TEST_PATH}:/file/bin\nLITERAL='${SAGE_TEST_HOME}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "app.env"), []byte(envFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		svc     models.Service
+		wantEnv map[string]string
+		wantArg []string
+		err     string
+	}{
+		{
+			name: "env refers to env and the daemon environment",
+			svc: models.Service{Env: map[string]string{
+				"DATA": "${BASE}/data",
+				"BASE": "${SAGE_TEST_HOME}/app",
+			}},
+			wantEnv: map[string]string{"DATA": "/home/sage/app/data", "BASE": "/home/sage/app"},
+		},
+		{
+			name: "self reference takes the layer below",
+			svc: models.Service{
+				EnvFile: []string{"app.env"},
+				Env:     map[string]string{"SAGE_TEST_PATH": "${SAGE_TEST_PATH}:/svc/bin"},
+			},
+			wantEnv: map[string]string{
+				"FROM_FILE":      "file",
+				"SAGE_TEST_PATH": "/usr/bin:/file/bin:/svc/bin",
+				"LITERAL":        "${SAGE_TEST_HOME}",
+			},
+		},
+		{
+			name: "service env wins over env files",
+			svc: models.Service{
+				EnvFile: []string{filepath.Join(dir, "app.env")},
+				Env:     map[string]string{"FROM_FILE": "svc", "USE": "${FROM_FILE}"},
+			},
+			wantEnv: map[string]string{
+				"FROM_FILE":      "svc",
+				"USE":            "svc",
+				"SAGE_TEST_PATH": "/usr/bin:/file/bin",
+				"LITERAL":        "${SAGE_TEST_HOME}",
+			},
+		},
+		{
+			name: "command, args and working directory",
+			svc: models.Service{
+				Command:    "${BIN}/server",
+				Args:       []string{"--home=${SAGE_TEST_HOME}", "$${NOT_EXPANDED}", "$HOME"},
+				WorkingDir: "${SAGE_TEST_HOME}",
+				Env:        map[string]string{"BIN": "/opt/bin"},
+			},
+			wantEnv: map[string]string{"BIN": "/opt/bin"},
+			wantArg: []string{"--home=/home/sage", "${NOT_EXPANDED}", "$HOME"},
+		},
+		{
+			name: "cycle",
+			svc:  models.Service{Env: map[string]string{"A": "${B}", "B": "${A}"}},
+			err:  "variable 'A' is part of a reference cycle",
+		},
+		{
+			name: "undefined",
+			svc:  models.Service{Env: map[string]string{"A": "${SAGE_TEST_UNSET}"}},
+			err:  "undefined variable 'SAGE_TEST_UNSET'",
+		},
+		{
+			name: "self reference without a layer below",
+			svc:  models.Service{Env: map[string]string{"SAGE_TEST_UNSET": "${SAGE_TEST_UNSET}"}},
+			err:  "undefined variable 'SAGE_TEST_UNSET'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveService(tt.svc, dir)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("resolveService() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveService() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.Env, tt.wantEnv) {
+				t.Errorf("Env = %v, want %v", got.Env, tt.wantEnv)
+			}
+			if tt.wantArg != nil {
+				if got.Command != "/opt/bin/server" || got.WorkingDir != "/home/sage" {
+					t.Errorf("Command, WorkingDir = %q, %q", got.Command, got.WorkingDir)
+				}
+				if !reflect.DeepEqual(got.Args, tt.wantArg) {
+					t.Errorf("Args = %q, want %q", got.Args, tt.wantArg)
+				}
+			}
+		})
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want map[string]string
+		err  string
+	}{
+		{
+			name: "plain",
+			file: "# comment\n\nA=1\nexport B = two words \nC=\nD=x # trailing\nE=x#y\n",
+			want: map[string]string{"A": "1", "B": "two words", "C": "", "D": "x", "E": "x#y"},
+		},
+		{
+			name: "double quotes",
+			file: `A="a # b"` + "\n" + `B="line\nnext\ttab \"q\" \\"` + "\n",
+			want: map[string]string{"A": "a # b", "B": "line\nnext\ttab \"q\" \\"},
+		},
+		{
+			name: "single quotes",
+			file: `A='a \n "b" ${C}'` + "\n",
+			want: map[string]string{"A": `a \n "b" $${C}`},
+		},
+		{
+			name: "missing equals",
+			file: "A=1\nB\n",
+			err:  "2: expected KEY=VALUE",
+		},
+		{
+			name: "invalid key",
+			file: "1A=x\n",
+			err:  "1: expected KEY=VALUE",
+		},
+		{
+			name: "unterminated double quote",
+			file: `A="x` + "\n",
+			err:  "1: unterminated double quote",
+		},
+		{
+			name: "unterminated single quote",
+			file: "A='x\n",
+			err:  "1: unterminated single quote",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.env")
+			if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseEnvFile(path)
+			if tt.err != "" {
+				if want := path + ":" + tt.err; err == nil || err.Error() != want {
+					t.Fatalf("parseEnvFile() error = %v, want %q", err, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseEnvFile() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEnvFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	defaults := models.Service{
+		WorkingDir: "/srv",
+		Env:        map[string]string{"LANG": "C", "MODE": "prod"},
+		Restart:    models.RestartPolicy{Policy: models.RestartAlways, MaxRetries: 5},
+		Autostart:  true,
+		Logs:       &models.LogConfig{Format: models.LogFormatJSON, MaxBackups: 7, Compress: true},
+	}
+	tests := []struct {
+		name string
+		path string
+		file string
+		want func(svc *models.Service)
+	}{
+		{
+			name: "unset fields",
+			path: "a.yaml",
+			file: "name: web\ncommand: /bin/web\n",
+			want: func(svc *models.Service) {
+				svc.WorkingDir = "/srv"
+				svc.Env = map[string]string{"LANG": "C", "MODE": "prod"}
+				svc.Restart = models.RestartPolicy{Policy: models.RestartAlways, MaxRetries: 5}
+				svc.Autostart = true
+				svc.Logs = &models.LogConfig{Format: models.LogFormatJSON, MaxBackups: 7, Compress: true}
+			},
+		},
+		{
+			name: "explicit zero values in yaml",
+			path: "a.yaml",
+			file: "name: web\ncommand: /bin/web\nautostart: false\nrestart: {maxRetries: 0}\nlogs: {maxBackups: 0, compress: false}\n",
+			want: func(svc *models.Service) {
+				svc.WorkingDir = "/srv"
+				svc.Env = map[string]string{"LANG": "C", "MODE": "prod"}
+				svc.Restart = models.RestartPolicy{Policy: models.RestartAlways}
+				svc.Logs = &models.LogConfig{Format: models.LogFormatJSON}
+			},
+		},
+		{
+			name: "explicit zero values in toml",
+			path: "a.toml",
+			file: "name = \"web\"\ncommand = \"/bin/web\"\nautostart = false\n[restart]\nmaxRetries = 0\n",
+			want: func(svc *models.Service) {
+				svc.WorkingDir = "/srv"
+				svc.Env = map[string]string{"LANG": "C", "MODE": "prod"}
+				svc.Restart = models.RestartPolicy{Policy: models.RestartAlways}
+				svc.Logs = &models.LogConfig{Format: models.LogFormatJSON, MaxBackups: 7, Compress: true}
+			},
+		},
+		{
+			name: "json keys in any case, env merged",
+			path: "a.json",
+			file: `{"name": "web", "command": "/bin/web", "AUTOSTART": false, "env": {"MODE": "dev"}}`,
+			want: func(svc *models.Service) {
+				svc.WorkingDir = "/srv"
+				svc.Env = map[string]string{"LANG": "C", "MODE": "dev"}
+				svc.Restart = models.RestartPolicy{Policy: models.RestartAlways, MaxRetries: 5}
+				svc.Logs = &models.LogConfig{Format: models.LogFormatJSON, MaxBackups: 7, Compress: true}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var svc models.Service
+			if err := decodeFile(tt.path, []byte(tt.file), &svc); err != nil {
+				t.Fatal(err)
+			}
+			var raw any
+			if err := decodeFile(tt.path, []byte(tt.file), &raw); err != nil {
+				t.Fatal(err)
+			}
+			got := applyDefaults(svc, defaults, raw, fileTag(tt.path))
+
+			want := models.Service{Name: "web", Command: "/bin/web"}
+			tt.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("applyDefaults() =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
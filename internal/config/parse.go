@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileNames are the names LoadConfig looks for in ~/.sage; the format
+// is picked from the extension.
+var configFileNames = []string{"sage-conf.json", "sage-conf.yaml", "sage-conf.yml", "sage-conf.toml"}
+
+// configExts are the extensions of the files read from conf.d.
+var configExts = []string{".json", ".yaml", ".yml", ".toml"}
+
+// ParseError points at the place in a config file that could not be parsed.
+// Line and Column start at 1; Column is 0 when the parser doesn't report it.
+type ParseError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	case e.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	default:
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+	}
+}
+
+// decodeFile unmarshals a JSON, YAML or TOML config file into v, depending
+// on the extension of path.
+func decodeFile(path string, b []byte, v any) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return decodeYAML(path, b, v)
+	case ".toml":
+		return decodeTOML(path, b, v)
+	default:
+		return decodeJSON(path, b, v)
+	}
+}
+
+// fileTag is the struct tag the decoder for path goes by.
+func fileTag(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	default:
+		return "json"
+	}
+}
+
+func decodeJSON(path string, b []byte, v any) error {
+	err := json.Unmarshal(b, v)
+	if err == nil {
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset := syntaxErr.Offset
+		// The offset counts the invalid character itself.
+		if strings.HasPrefix(syntaxErr.Error(), "invalid character") {
+			offset--
+		}
+		line, col := position(b, offset)
+		return &ParseError{File: path, Line: line, Column: col, Msg: syntaxErr.Error()}
+	case errors.As(err, &typeErr):
+		line, col := position(b, typeErr.Offset)
+		msg := fmt.Sprintf("cannot use %s as %s for '%s'", typeErr.Value, typeErr.Type, typeErr.Field)
+		return &ParseError{File: path, Line: line, Column: col, Msg: msg}
+	default:
+		return &ParseError{File: path, Msg: err.Error()}
+	}
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+func decodeYAML(path string, b []byte, v any) error {
+	err := yaml.Unmarshal(b, v)
+	if err == nil {
+		return nil
+	}
+
+	msgs := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msgs = typeErr.Errors
+	}
+	// yaml.v3 only reports lines, so the first problem gives the position
+	// and the rest are folded into the message.
+	line := 0
+	if m := yamlLine.FindStringSubmatch(msgs[0]); m != nil {
+		line, _ = strconv.Atoi(m[1])
+		msgs[0] = strings.TrimPrefix(msgs[0], m[0])
+	}
+	return &ParseError{File: path, Line: line, Msg: strings.Join(msgs, "; ")}
+}
+
+var tomlLine = regexp.MustCompile(`^toml: line (\d+)(?: \(last key "[^"]*"\))?: `)
+
+func decodeTOML(path string, b []byte, v any) error {
+	_, err := toml.NewDecoder(bytes.NewReader(b)).Decode(v)
+	if err == nil {
+		return nil
+	}
+
+	var parseErr toml.ParseError
+	if errors.As(err, &parseErr) {
+		return &ParseError{File: path, Line: parseErr.Position.Line, Column: parseErr.Position.Col, Msg: parseErr.Message}
+	}
+	// Type mismatches aren't ParseErrors and only carry the line in the text.
+	if m := tomlLine.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return &ParseError{File: path, Line: line, Msg: strings.TrimPrefix(err.Error(), m[0])}
+	}
+	return &ParseError{File: path, Msg: err.Error()}
+}
+
+// position converts a byte offset into a 1-based line and column.
+func position(b []byte, offset int64) (int, int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	before := b[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
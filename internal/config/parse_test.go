@@ -0,0 +1,130 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+func TestDecodeFile(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		file string
+		want models.Services
+	}{
+		{
+			name: "json",
+			path: "sage-conf.json",
+			file: `{"maxParallel": 2, "services": [{"name": "web", "command": "sh", "stopTimeout": "5s"}]}`,
+		},
+		{
+			name: "yaml",
+			path: "sage-conf.yml",
+			file: "maxParallel: 2\nservices:\n  - name: web\n    command: sh\n    stopTimeout: 5s\n",
+		},
+		{
+			name: "toml",
+			path: "sage-conf.toml",
+			file: "maxParallel = 2\n\n[[services]]\nname = \"web\"\ncommand = \"sh\"\nstopTimeout = \"5s\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got models.Services
+			if err := decodeFile(tt.path, []byte(tt.file), &got); err != nil {
+				t.Fatalf("decodeFile() error = %v", err)
+			}
+			if got.MaxParallel != 2 || len(got.Services) != 1 {
+				t.Fatalf("decodeFile() = %+v", got)
+			}
+			svc := got.Services[0]
+			if svc.Name != "web" || svc.Command != "sh" || svc.StopTimeout.Seconds() != 5 {
+				t.Errorf("services[0] = %+v", svc)
+			}
+		})
+	}
+}
+
+func TestDecodeFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		file string
+		want ParseError
+	}{
+		{
+			name: "json syntax",
+			path: "sage-conf.json",
+			file: "{\n  \"services\": [\n    {\"name\": \"web\",}\n  ]\n}\n",
+			want: ParseError{Line: 3, Column: 20, Msg: "invalid character '}' looking for beginning of object key string"},
+		},
+		{
+			name: "json type",
+			path: "sage-conf.json",
+			file: "{\n  \"maxParallel\": \"two\"\n}\n",
+			want: ParseError{Line: 2, Column: 23, Msg: "cannot use string as int for 'maxParallel'"},
+		},
+		{
+			name: "json truncated",
+			path: "sage-conf.json",
+			file: "{\"services\": [",
+			want: ParseError{Line: 1, Column: 15, Msg: "unexpected end of JSON input"},
+		},
+		{
+			name: "yaml syntax",
+			path: "sage-conf.yaml",
+			file: "maxParallel: 2\nshutdown: stop\n  include: x\n",
+			want: ParseError{Line: 3, Msg: "mapping values are not allowed in this context"},
+		},
+		{
+			name: "yaml types",
+			path: "sage-conf.yaml",
+			file: "maxParallel: two\nservices:\n  - name: web\n    autostart: maybe\n",
+			want: ParseError{Line: 1, Msg: "cannot unmarshal !!str `two` into int; line 4: cannot unmarshal !!str `maybe` into bool"},
+		},
+		{
+			name: "toml syntax",
+			path: "sage-conf.toml",
+			file: "maxParallel = 2\nshutdown = \n",
+			want: ParseError{Line: 2, Column: 12, Msg: "expected value but found '\\n' instead"},
+		},
+		{
+			name: "toml type",
+			path: "sage-conf.toml",
+			file: "\nmaxParallel = \"two\"\n",
+			want: ParseError{Line: 2, Msg: "incompatible types: TOML value has type string; destination has type integer"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v models.Services
+			err := decodeFile(tt.path, []byte(tt.file), &v)
+			var got *ParseError
+			if !errors.As(err, &got) {
+				t.Fatalf("decodeFile() error = %v, want a *ParseError", err)
+			}
+			tt.want.File = tt.path
+			if *got != tt.want {
+				t.Errorf("decodeFile() error = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrorString(t *testing.T) {
+	tests := []struct {
+		err  ParseError
+		want string
+	}{
+		{ParseError{File: "a.json", Msg: "bad"}, "a.json: bad"},
+		{ParseError{File: "a.yaml", Line: 3, Msg: "bad"}, "a.yaml:3: bad"},
+		{ParseError{File: "a.toml", Line: 3, Column: 7, Msg: "bad"}, "a.toml:3:7: bad"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
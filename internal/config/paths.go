@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// legacySocketPath is where the socket lives when there is no
+// XDG_RUNTIME_DIR, and where daemons before XDG support put it.
+const legacySocketPath = "/tmp/sage.sock"
+
+// Paths are the files and directories a daemon works with. Running a second
+// daemon only needs a second set of them.
+type Paths struct {
+	// Config is the config file. When empty, LoadConfig looks for one in
+	// ~/.sage.
+	Config    string
+	Socket    string
+	StateDir  string
+	LogDir    string
+	DaemonLog string
+}
+
+// PathsFromEnv reads the SAGE_CONFIG, SAGE_SOCKET, SAGE_STATE_DIR and
+// SAGE_LOG_DIR environment variables. Flags are meant to override them.
+func PathsFromEnv() Paths {
+	return Paths{
+		Config:   os.Getenv("SAGE_CONFIG"),
+		Socket:   os.Getenv("SAGE_SOCKET"),
+		StateDir: os.Getenv("SAGE_STATE_DIR"),
+		LogDir:   os.Getenv("SAGE_LOG_DIR"),
+	}
+}
+
+// WithDefaults fills in every path that wasn't set. State lives in ~/.sage,
+// service logs in ~/.sage/logs and the daemon log in ~/.sage/saged unless a
+// log directory is given, in which case the daemon log goes in a saged
+// directory in it, apart from the log of a service called saged.
+func (p Paths) WithDefaults() (Paths, error) {
+	if p.Socket == "" {
+		p.Socket = DefaultSocketPath()
+	}
+	if p.StateDir != "" && p.LogDir != "" {
+		p.DaemonLog = filepath.Join(p.LogDir, "saged", "saged.log")
+		return p, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return p, fmt.Errorf("error locating home directory")
+	}
+	if p.StateDir == "" {
+		p.StateDir = filepath.Join(homeDir, ".sage")
+	}
+	if p.LogDir == "" {
+		p.LogDir = filepath.Join(homeDir, ".sage", "logs")
+		p.DaemonLog = filepath.Join(homeDir, ".sage", "saged", "saged.log")
+	} else {
+		p.DaemonLog = filepath.Join(p.LogDir, "saged", "saged.log")
+	}
+	return p, nil
+}
+
+// DefaultSocketPath is $XDG_RUNTIME_DIR/sage.sock, or /tmp/sage.sock when
+// XDG_RUNTIME_DIR isn't set.
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "sage.sock")
+	}
+	return legacySocketPath
+}
+
+// FindSocket returns the socket a client should connect to when none was
+// given. It falls back to /tmp/sage.sock if nothing listens at the default,
+// which is the case for a daemon started without XDG_RUNTIME_DIR (e.g. by
+// systemd) while the client has it set.
+func FindSocket() string {
+	path := DefaultSocketPath()
+	if _, err := os.Stat(path); err != nil {
+		if _, err := os.Stat(legacySocketPath); err == nil {
+			return legacySocketPath
+		}
+	}
+	return path
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// runs returns the next n run times of expr after start.
+func runs(t *testing.T, expr string, start time.Time, n int) []string {
+	t.Helper()
+	s, err := ParseSchedule(expr)
+	if err != nil {
+		t.Fatalf("ParseSchedule(%q) error = %v", expr, err)
+	}
+	var out []string
+	next := start
+	for range n {
+		next = s.Next(next)
+		out = append(out, next.Format("2006-01-02 15:04:05 MST"))
+	}
+	return out
+}
+
+func TestScheduleNext(t *testing.T) {
+	// A Thursday.
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"* * * * *", []string{"2026-01-01 00:01:00 UTC", "2026-01-01 00:02:00 UTC"}},
+		{"*/15 * * * *", []string{"2026-01-01 00:15:00 UTC", "2026-01-01 00:30:00 UTC", "2026-01-01 00:45:00 UTC", "2026-01-01 01:00:00 UTC"}},
+		{"5,10-12 * * * *", []string{"2026-01-01 00:05:00 UTC", "2026-01-01 00:10:00 UTC", "2026-01-01 00:11:00 UTC", "2026-01-01 00:12:00 UTC", "2026-01-01 01:05:00 UTC"}},
+		{"5/20 * * * *", []string{"2026-01-01 00:05:00 UTC", "2026-01-01 00:25:00 UTC", "2026-01-01 00:45:00 UTC", "2026-01-01 01:05:00 UTC"}},
+		{"0 9-17/4 * * *", []string{"2026-01-01 09:00:00 UTC", "2026-01-01 13:00:00 UTC", "2026-01-01 17:00:00 UTC", "2026-01-02 09:00:00 UTC"}},
+		{"30 2 * * mon-fri", []string{"2026-01-01 02:30:00 UTC", "2026-01-02 02:30:00 UTC", "2026-01-05 02:30:00 UTC"}},
+		{"0 0 * * 7", []string{"2026-01-04 00:00:00 UTC", "2026-01-11 00:00:00 UTC"}},
+		{"0 0 * * SUN,wed", []string{"2026-01-04 00:00:00 UTC", "2026-01-07 00:00:00 UTC", "2026-01-11 00:00:00 UTC"}},
+		{"0 0 1 jan-mar/2 *", []string{"2026-03-01 00:00:00 UTC", "2027-01-01 00:00:00 UTC", "2027-03-01 00:00:00 UTC"}},
+		{"0 0 31 * *", []string{"2026-01-31 00:00:00 UTC", "2026-03-31 00:00:00 UTC", "2026-05-31 00:00:00 UTC"}},
+		{"0 0 29 2 *", []string{"2028-02-29 00:00:00 UTC", "2032-02-29 00:00:00 UTC"}},
+		// Day of month or day of week when both are restricted.
+		{"0 0 13 * fri", []string{"2026-01-02 00:00:00 UTC", "2026-01-09 00:00:00 UTC", "2026-01-13 00:00:00 UTC", "2026-01-16 00:00:00 UTC"}},
+		// Both when either starts with a star.
+		{"0 0 1-7 * */1", []string{"2026-01-02 00:00:00 UTC", "2026-01-03 00:00:00 UTC"}},
+		{"0 0 */10 * mon", []string{"2026-05-11 00:00:00 UTC", "2026-06-01 00:00:00 UTC"}},
+		{"@hourly", []string{"2026-01-01 01:00:00 UTC", "2026-01-01 02:00:00 UTC"}},
+		{"@daily", []string{"2026-01-02 00:00:00 UTC", "2026-01-03 00:00:00 UTC"}},
+		{"@weekly", []string{"2026-01-04 00:00:00 UTC", "2026-01-11 00:00:00 UTC"}},
+		{"@monthly", []string{"2026-02-01 00:00:00 UTC", "2026-03-01 00:00:00 UTC"}},
+		{"@YEARLY", []string{"2027-01-01 00:00:00 UTC", "2028-01-01 00:00:00 UTC"}},
+		{"@every 90s", []string{"2026-01-01 00:01:30 UTC", "2026-01-01 00:03:00 UTC"}},
+	}
+	for _, tt := range tests {
+		if got := runs(t, tt.expr, start, len(tt.want)); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: runs = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+
+	s, err := ParseSchedule("@every 1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, 1, 1, 0, 0, 30, 500, time.UTC)
+	if got, want := s.Next(from), time.Date(2026, 1, 1, 0, 1, 30, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("@every 1m: Next(%v) = %v, want %v", from, got, want)
+	}
+}
+
+func TestScheduleNever(t *testing.T) {
+	s, err := ParseSchedule("0 0 30 feb *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Errorf("Next() = %v, want the zero time", next)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"* * * *", "invalid schedule '* * * *': expected 5 fields, got 4"},
+		{"60 * * * *", "invalid schedule '60 * * * *': minute: 60 is out of range 0-59"},
+		{"* 24 * * *", "invalid schedule '* 24 * * *': hour: 24 is out of range 0-23"},
+		{"* * 0 * *", "invalid schedule '* * 0 * *': day of month: 0 is out of range 1-31"},
+		{"* * * 13 *", "invalid schedule '* * * 13 *': month: 13 is out of range 1-12"},
+		{"* * * * 8", "invalid schedule '* * * * 8': day of week: 8 is out of range 0-7"},
+		{"*/0 * * * *", "invalid schedule '*/0 * * * *': minute: invalid step '0'"},
+		{"5-1 * * * *", "invalid schedule '5-1 * * * *': minute: invalid range '5-1'"},
+		{"* * * foo *", "invalid schedule '* * * foo *': month: invalid value 'foo'"},
+		{"@often", "invalid schedule '@often': unknown macro"},
+		{"@every 500ms", "invalid schedule '@every 500ms': the interval must be at least 1s"},
+		{"@every soon", `invalid schedule '@every soon': time: invalid duration "soon"`},
+	}
+	for _, tt := range tests {
+		if _, err := ParseSchedule(tt.expr); err == nil || err.Error() != tt.err {
+			t.Errorf("ParseSchedule(%q) error = %v, want %q", tt.expr, err, tt.err)
+		}
+	}
+}
+
+func TestScheduleNextDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// The clocks go back from 2:00 EDT to 1:00 EST on November 1, 2026 and
+	// forward from 2:00 EST to 3:00 EDT on March 8, 2026.
+	back := time.Date(2026, 11, 1, 0, 45, 0, 0, loc)
+	forward := time.Date(2026, 3, 8, 0, 45, 0, 0, loc)
+	tests := []struct {
+		expr  string
+		start time.Time
+		want  []string
+	}{
+		{"30 1 * * *", back, []string{"2026-11-01 01:30:00 EDT", "2026-11-02 01:30:00 EST"}},
+		{"*/30 1 * * *", back, []string{"2026-11-01 01:00:00 EDT", "2026-11-01 01:30:00 EDT", "2026-11-02 01:00:00 EST"}},
+		{"*/30 * * * *", back, []string{"2026-11-01 01:00:00 EDT", "2026-11-01 01:30:00 EDT", "2026-11-01 01:00:00 EST", "2026-11-01 01:30:00 EST", "2026-11-01 02:00:00 EST"}},
+		{"0 2 * * *", back, []string{"2026-11-01 02:00:00 EST", "2026-11-02 02:00:00 EST"}},
+		{"30 1 * * *", forward, []string{"2026-03-08 01:30:00 EST", "2026-03-09 01:30:00 EDT"}},
+		{"30 2 * * *", forward, []string{"2026-03-09 02:30:00 EDT", "2026-03-10 02:30:00 EDT"}},
+		{"*/30 * * * *", forward, []string{"2026-03-08 01:00:00 EST", "2026-03-08 01:30:00 EST", "2026-03-08 03:00:00 EDT"}},
+	}
+	for _, tt := range tests {
+		if got := runs(t, tt.expr, tt.start, len(tt.want)); !slices.Equal(got, tt.want) {
+			t.Errorf("%q from %v: runs = %q, want %q", tt.expr, tt.start, got, tt.want)
+		}
+	}
+
+	// Where the clocks go forward at midnight, the day still has its runs.
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2026, 9, 5, 22, 0, 0, 0, santiago)
+	want := []string{"2026-09-06 01:00:00 -03", "2026-09-07 00:00:00 -03"}
+	if got := runs(t, "0 0,1 * * *", start, len(want)); !slices.Equal(got, want) {
+		t.Errorf("Santiago: runs = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,475 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+	"golang.org/x/sys/unix"
+)
+
+// reservedName can't be used for a service because sagectl takes it to mean
+// every service.
+const reservedName = "all"
+
+var envKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidationError lists every problem found in a config that parsed but
+// can't be used. File is the main config file; problems in other files are
+// prefixed with their path relative to it.
+type ValidationError struct {
+	File     string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %d problem(s):", e.File, len(e.Problems))
+	for _, p := range e.Problems {
+		fmt.Fprintf(&sb, "\n  - %s", p)
+	}
+	return sb.String()
+}
+
+// ParseSignal accepts signal names with or without the SIG prefix ("TERM",
+// "SIGINT") and defaults to SIGTERM when the name is empty.
+func ParseSignal(name string) (syscall.Signal, error) {
+	if name == "" {
+		return syscall.SIGTERM, nil
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("unknown signal '%s'", name)
+	}
+	return sig, nil
+}
+
+// mainOnlyKeys are the top-level settings that are only read from the main
+// config file.
+var mainOnlyKeys = []string{"maxParallel", "shutdown", "include", "defaults", "daemonLog"}
+
+// validate checks the parsed config files, the first of which is the main
+// one, and merges their services into a map. It also returns the file every
+// service came from.
+func validate(files []configFile, problems []string) (map[string]models.Service, map[string]string, error) {
+	mainPath := files[0].path
+	where := func(path string) string {
+		if path == mainPath {
+			return ""
+		}
+		if rel, err := filepath.Rel(filepath.Dir(mainPath), path); err == nil {
+			path = rel
+		}
+		return path + ": "
+	}
+	problemf := func(path, format string, args ...any) {
+		problems = append(problems, where(path)+fmt.Sprintf(format, args...))
+	}
+
+	m := make(map[string]models.Service)
+	sources := make(map[string]string)
+	// raws are the services as they were written, for applyDefaults.
+	raws := make(map[string]any)
+	for i, f := range files {
+		for _, p := range unknownFields(f.raw, reflect.TypeOf(f.services), fileTag(f.path), "") {
+			problemf(f.path, "%s", p)
+		}
+		if i == 0 {
+			problems = append(problems, validateSettings(f.services)...)
+		} else {
+			for _, key := range mainOnlyKeys {
+				if _, exists := f.raw[key]; exists {
+					problemf(f.path, "%s is only read from the main config file", key)
+				}
+			}
+		}
+
+		seen := make(map[string]int)
+		for j, svc := range f.services.Services {
+			if svc.Name == "" {
+				problemf(f.path, "services[%d]: name is required", j)
+				continue
+			}
+			if first, exists := seen[svc.Name]; exists {
+				problemf(f.path, "service '%s': defined more than once (services[%d] and services[%d])", svc.Name, first, j)
+				continue
+			}
+			seen[svc.Name] = j
+			m[svc.Name] = svc
+			sources[svc.Name] = f.path
+			if rawServices, set := rawField(f.raw, "services", fileTag(f.path)); set {
+				raws[svc.Name] = rawIndex(rawServices, j)
+			}
+		}
+	}
+
+	defaults := files[0].services.Defaults
+	if defaults.Name != "" {
+		problemf(mainPath, "defaults: name can't have a default")
+	}
+	defaults.EnvFile = slices.Clone(defaults.EnvFile)
+	for i, path := range defaults.EnvFile {
+		if !filepath.IsAbs(path) {
+			defaults.EnvFile[i] = filepath.Join(filepath.Dir(mainPath), path)
+		}
+	}
+	for _, name := range ServiceNames(m) {
+		svc := applyDefaults(m[name], defaults, raws[name], fileTag(sources[name]))
+		resolved, err := resolveService(svc, filepath.Dir(sources[name]))
+		if err != nil {
+			// Kept as it is, so that its dependents still find it.
+			problemf(sources[name], "service '%s': %v", name, err)
+			m[name] = svc
+			continue
+		}
+		svc = resolved
+		m[name] = svc
+		for _, p := range validateService(svc) {
+			problemf(sources[name], "service '%s': %s", name, p)
+		}
+	}
+	if _, err := StartOrder(m, ServiceNames(m)); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) > 0 {
+		return nil, nil, &ValidationError{File: mainPath, Problems: problems}
+	}
+	return m, sources, nil
+}
+
+func validateSettings(services models.Services) []string {
+	var problems []string
+	if services.MaxParallel < 0 {
+		problems = append(problems, fmt.Sprintf("maxParallel must not be negative, got %d", services.MaxParallel))
+	}
+	switch services.Shutdown {
+	case "", models.ShutdownLeave, models.ShutdownStop:
+	default:
+		problems = append(problems, fmt.Sprintf("shutdown must be '%s' or '%s', got '%s'", models.ShutdownLeave, models.ShutdownStop, services.Shutdown))
+	}
+	if level := services.DaemonLog.Level; level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			problems = append(problems, fmt.Sprintf("daemonLog.level must be debug, info, warn or error, got '%s'", level))
+		}
+	}
+	switch services.DaemonLog.Format {
+	case "", models.LogFormatText, models.LogFormatJSON:
+	default:
+		problems = append(problems, fmt.Sprintf("daemonLog.format must be '%s' or '%s', got '%s'", models.LogFormatText, models.LogFormatJSON, services.DaemonLog.Format))
+	}
+	if n := services.DaemonLog.MaxBackups; n != nil && *n < 0 {
+		problems = append(problems, fmt.Sprintf("daemonLog.maxBackups must not be negative, got %d", *n))
+	}
+	return problems
+}
+
+func validateService(svc models.Service) []string {
+	var problems []string
+	problemf := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if svc.Name == reservedName {
+		problemf("the name '%s' is reserved", reservedName)
+	}
+	if strings.ContainsAny(svc.Name, "/ \t\n") {
+		problemf("name must not contain slashes or whitespace")
+	}
+
+	workingDirOK := true
+	if svc.WorkingDir != "" {
+		info, err := os.Stat(svc.WorkingDir)
+		switch {
+		case err != nil:
+			workingDirOK = false
+			problemf("working directory '%s': %v", svc.WorkingDir, statReason(err))
+		case !info.IsDir():
+			workingDirOK = false
+			problemf("working directory '%s' is not a directory", svc.WorkingDir)
+		}
+	}
+
+	if svc.Command == "" {
+		problemf("command is required")
+	} else if workingDirOK {
+		if err := checkExecutable(svc.Command, svc.WorkingDir); err != nil {
+			problemf("command '%s': %v", svc.Command, err)
+		}
+	}
+
+	if svc.User != "" {
+		if _, err := lookupUser(svc.User); err != nil {
+			problemf("%v", err)
+		}
+	}
+	if svc.Group != "" {
+		if _, err := lookupGroup(svc.Group); err != nil {
+			problemf("%v", err)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(svc.Env)) {
+		if !envKey.MatchString(key) {
+			problemf("env key '%s' must be letters, digits and underscores and not start with a digit", key)
+		}
+	}
+
+	switch svc.Restart.Policy {
+	case "", models.RestartNever, models.RestartOnFailure, models.RestartAlways:
+	default:
+		problemf("restart.policy must be '%s', '%s' or '%s', got '%s'", models.RestartNever, models.RestartOnFailure, models.RestartAlways, svc.Restart.Policy)
+	}
+	if svc.Restart.Jitter < 0 {
+		problemf("restart.jitter must not be negative")
+	}
+
+	if l := svc.Limits; l != nil {
+		if l.CPU < 0 {
+			problemf("limits.cpu must not be negative")
+		}
+		if l.Pids < 0 {
+			problemf("limits.pids must not be negative")
+		}
+	}
+
+	if l := svc.Logs; l != nil {
+		switch l.Format {
+		case "", models.LogFormatText, models.LogFormatJSON:
+		default:
+			problemf("logs.format must be '%s' or '%s', got '%s'", models.LogFormatText, models.LogFormatJSON, l.Format)
+		}
+		if l.MaxBackups < 0 {
+			problemf("logs.maxBackups must not be negative")
+		}
+		if l.MaxAge.Duration < 0 {
+			problemf("logs.maxAge must not be negative")
+		}
+	}
+
+	for i, t := range svc.Thresholds {
+		for _, p := range validateThreshold(t) {
+			problemf("thresholds[%d]: %s", i, p)
+		}
+	}
+
+	if svc.Schedule != "" {
+		if sched, err := ParseSchedule(svc.Schedule); err != nil {
+			problemf("%v", err)
+		} else if sched.Next(time.Now()).IsZero() {
+			problemf("schedule: '%s' never runs", svc.Schedule)
+		}
+		// A job that is respawned or started at boot would still be
+		// running whenever its next run is due.
+		if policy := svc.Restart.Policy; policy == models.RestartAlways || policy == models.RestartOnFailure {
+			problemf("schedule: a scheduled service can't have restart policy '%s'", policy)
+		}
+		if svc.Autostart {
+			problemf("schedule: a scheduled service can't have autostart")
+		}
+	}
+	switch svc.Overlap {
+	case "", models.OverlapSkip, models.OverlapQueue, models.OverlapReplace:
+	default:
+		problemf("overlap must be '%s', '%s' or '%s', got '%s'", models.OverlapSkip, models.OverlapQueue, models.OverlapReplace, svc.Overlap)
+	}
+
+	if _, err := ParseSignal(svc.StopSignal); err != nil {
+		problemf("stopSignal: %v", err)
+	}
+
+	if svc.Readiness != nil {
+		for _, p := range validateProbe(*svc.Readiness) {
+			problemf("readiness: %s", p)
+		}
+	}
+	if svc.Liveness != nil {
+		for _, p := range validateProbe(svc.Liveness.Probe) {
+			problemf("liveness: %s", p)
+		}
+	}
+	return problems
+}
+
+func validateThreshold(t models.Threshold) []string {
+	var problems []string
+	metrics := 0
+	for _, set := range []bool{t.CPU != 0, t.Memory != 0, t.RSS != 0} {
+		if set {
+			metrics++
+		}
+	}
+	if metrics != 1 {
+		problems = append(problems, "exactly one of cpu, memory and rss must be set")
+	}
+	if t.CPU < 0 || t.Memory < 0 {
+		problems = append(problems, "cpu and memory must not be negative")
+	}
+	if t.Samples < 0 {
+		problems = append(problems, "samples must not be negative")
+	}
+	if t.Samples > 0 && t.For.Duration > 0 {
+		problems = append(problems, "only one of samples and for can be set")
+	}
+	switch t.Action {
+	case "", models.ActionWarn, models.ActionEvent, models.ActionRestart:
+	default:
+		problems = append(problems, fmt.Sprintf("action must be '%s', '%s' or '%s', got '%s'", models.ActionWarn, models.ActionEvent, models.ActionRestart, t.Action))
+	}
+	return problems
+}
+
+func validateProbe(p models.Probe) []string {
+	switch p.Type {
+	case models.ProbeTCP:
+		if p.Address == "" {
+			return []string{"tcp probe needs an address"}
+		}
+	case models.ProbeHTTP:
+		if p.URL == "" {
+			return []string{"http probe needs a url"}
+		}
+	case models.ProbeExec:
+		if len(p.Command) == 0 {
+			return []string{"exec probe needs a command"}
+		}
+	case models.ProbeLog:
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return []string{fmt.Sprintf("invalid pattern: %v", err)}
+		}
+	default:
+		return []string{fmt.Sprintf("type must be one of %s, %s, %s or %s, got '%s'", models.ProbeTCP, models.ProbeHTTP, models.ProbeExec, models.ProbeLog, p.Type)}
+	}
+	return nil
+}
+
+// checkExecutable resolves command the way exec.Command does when the
+// service is spawned and checks that it can be run. Relative paths are taken
+// from the working directory.
+func checkExecutable(command, workingDir string) error {
+	path := command
+	if !strings.Contains(command, "/") {
+		found, err := exec.LookPath(command)
+		if err != nil {
+			return fmt.Errorf("not found in PATH")
+		}
+		path = found
+	} else if !filepath.IsAbs(command) && workingDir != "" {
+		path = filepath.Join(workingDir, command)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return statReason(err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("is a directory")
+	}
+	if err := unix.Access(path, unix.X_OK); err != nil {
+		return fmt.Errorf("is not executable")
+	}
+	return nil
+}
+
+func statReason(err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("does not exist")
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("permission denied")
+	default:
+		return err
+	}
+}
+
+// unknownFields walks a schemaless decoding of a config file alongside the
+// struct it is meant for and reports every key that the struct doesn't have,
+// as seen through the struct tags of the file's format.
+func unknownFields(raw any, t reflect.Type, tag, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	v := reflect.ValueOf(raw)
+
+	switch {
+	case t.Kind() == reflect.Struct && v.Kind() == reflect.Map:
+		fields := make(map[string]reflect.Type)
+		collectFields(t, tag, fields)
+
+		values := make(map[string]any)
+		iter := v.MapRange()
+		for iter.Next() {
+			values[fmt.Sprint(iter.Key().Interface())] = iter.Value().Interface()
+		}
+
+		var problems []string
+		for _, key := range slices.Sorted(maps.Keys(values)) {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			ft, exists := fields[key]
+			if !exists && tag == "json" {
+				// encoding/json matches keys case-insensitively.
+				for name, t := range fields {
+					if strings.EqualFold(name, key) {
+						ft, exists = t, true
+					}
+				}
+			}
+			if !exists {
+				problems = append(problems, fmt.Sprintf("%s: unknown field", fieldPath))
+				continue
+			}
+			problems = append(problems, unknownFields(values[key], ft, tag, fieldPath)...)
+		}
+		return problems
+
+	case t.Kind() == reflect.Slice && v.Kind() == reflect.Slice:
+		var problems []string
+		for i := 0; i < v.Len(); i++ {
+			problems = append(problems, unknownFields(v.Index(i).Interface(), t.Elem(), tag, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+	}
+	return nil
+}
+
+// collectFields maps the key of every field of t to its type, flattening
+// embedded structs the way the decoders do.
+func collectFields(t reflect.Type, tag string, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			collectFields(f.Type, tag, fields)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+}
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+// writeFiles writes files, named relative to dir, and returns the path of
+// the first one listed in order, the main config file.
+func writeFiles(t *testing.T, dir string, order []string, files map[string]string) string {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, order[0])
+}
+
+func TestLoadFileProblems(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		main  string
+		want  []string
+	}{
+		{
+			name: "unknown fields",
+			main: "sage-conf.yaml",
+			files: map[string]string{"sage-conf.yaml": `
+maxParalel: 2
+services:
+  - name: web
+    command: sh
+    comand: sh
+    restart: {policy: always, delay: 1s}
+`},
+			want: []string{
+				"maxParalel: unknown field",
+				"services[0].comand: unknown field",
+				"services[0].restart.delay: unknown field",
+			},
+		},
+		{
+			name: "embedded probe fields",
+			main: "sage-conf.yaml",
+			files: map[string]string{"sage-conf.yaml": `
+services:
+  - name: web
+    command: sh
+    liveness: {type: tcp, address: "localhost:80", failureThreshold: 3, retries: 2}
+`},
+			want: []string{"services[0].liveness.retries: unknown field"},
+		},
+		{
+			name: "json keys in any case",
+			main: "sage-conf.json",
+			files: map[string]string{"sage-conf.json": `{
+  "MaxParallel": 2,
+  "services": [{"NAME": "web", "Command": "sh", "liveness": {"TYPE": "tcp", "Address": "localhost:80", "FailureThreshold": 3, "Bogus": 1}}]
+}`},
+			want: []string{"services[0].liveness.Bogus: unknown field"},
+		},
+		{
+			name: "toml keys are case-sensitive",
+			main: "sage-conf.toml",
+			files: map[string]string{"sage-conf.toml": `
+[[services]]
+name = "web"
+Command = "sh"
+`},
+			want: []string{
+				"services[0].Command: unknown field",
+				"service 'web': command is required",
+			},
+		},
+		{
+			name: "names",
+			main: "sage-conf.yaml",
+			files: map[string]string{"sage-conf.yaml": `
+services:
+  - command: sh
+  - name: web
+    command: sh
+  - name: web
+    command: sh
+  - name: all
+    command: sh
+`},
+			want: []string{
+				"services[0]: name is required",
+				"service 'web': defined more than once (services[1] and services[2])",
+				"service 'all': the name 'all' is reserved",
+			},
+		},
+		{
+			name: "main-only keys",
+			main: "sage-conf.yaml",
+			files: map[string]string{
+				"sage-conf.yaml":    "services: []\n",
+				"conf.d/extra.yaml": "maxParallel: 2\nshutdown: stop\nservices: []\n",
+			},
+			want: []string{
+				"conf.d/extra.yaml: maxParallel is only read from the main config file",
+				"conf.d/extra.yaml: shutdown is only read from the main config file",
+			},
+		},
+		{
+			name: "dependents of a service that fails to resolve",
+			main: "sage-conf.yaml",
+			files: map[string]string{"sage-conf.yaml": `
+services:
+  - name: db
+    command: sh
+    env: {DATA: "${SAGE_TEST_UNSET}"}
+  - name: web
+    command: sh
+    dependsOn: [db]
+`},
+			want: []string{"service 'db': undefined variable 'SAGE_TEST_UNSET'"},
+		},
+		{
+			name: "dependencies",
+			main: "sage-conf.yaml",
+			files: map[string]string{"sage-conf.yaml": `
+services:
+  - name: a
+    command: sh
+    dependsOn: [b]
+  - name: b
+    command: sh
+    dependsOn: [a]
+`},
+			want: []string{"dependency cycle: a -> b -> a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFiles(t, t.TempDir(), []string{tt.main}, tt.files)
+			_, err := LoadFile(path)
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("LoadFile() error = %v, want a *ValidationError", err)
+			}
+			if verr.File != path {
+				t.Errorf("File = %q, want %q", verr.File, path)
+			}
+			if !slices.Equal(verr.Problems, tt.want) {
+				t.Errorf("Problems =\n%q\nwant\n%q", verr.Problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFileMerge(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFiles(t, dir, []string{"sage-conf.yaml"}, map[string]string{
+		"sage-conf.yaml": `
+maxParallel: 3
+include: ["apps/*.toml"]
+services:
+  - name: web
+    command: sh
+    args: [main]
+  - name: db
+    command: sh
+`,
+		"conf.d/b.yaml":   "services: [{name: web, command: sh, args: [b]}]\n",
+		"conf.d/a.json":   `{"services": [{"name": "web", "command": "sh", "args": ["a"]}, {"name": "cache", "command": "sh"}]}`,
+		"conf.d/notes.md": "not a config file",
+		"apps/x.toml":     "[[services]]\nname = \"worker\"\ncommand = \"sh\"\n",
+	})
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if cfg.MaxParallel != 3 {
+		t.Errorf("MaxParallel = %d, want 3", cfg.MaxParallel)
+	}
+	if got, want := ServiceNames(cfg.ServiceMap), []string{"cache", "db", "web", "worker"}; !slices.Equal(got, want) {
+		t.Errorf("services = %v, want %v", got, want)
+	}
+	// conf.d is read in lexical order, after the main file.
+	if got := cfg.ServiceMap["web"].Args; !slices.Equal(got, []string{"b"}) {
+		t.Errorf("web args = %v, want [b]", got)
+	}
+	wantSources := map[string]string{
+		"web":    filepath.Join(dir, "conf.d/b.yaml"),
+		"db":     path,
+		"cache":  filepath.Join(dir, "conf.d/a.json"),
+		"worker": filepath.Join(dir, "apps/x.toml"),
+	}
+	if !reflect.DeepEqual(cfg.Sources, wantSources) {
+		t.Errorf("Sources = %v, want %v", cfg.Sources, wantSources)
+	}
+}
+
+func TestLoadFileIncludes(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFiles(t, dir, []string{"sage-conf.yaml"}, map[string]string{
+		"sage-conf.yaml": "include: [\"apps/*.yaml\"]\nservices: [{name: web, command: sh}]\n",
+	})
+	// An include that matches nothing yet is fine.
+	if _, err := LoadFile(path); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+
+	path = writeFiles(t, dir, []string{"sage-conf.yaml"}, map[string]string{
+		"sage-conf.yaml": "include: [\"apps/[\"]\nservices: []\n",
+	})
+	_, err := LoadFile(path)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("LoadFile() error = %v, want a *ValidationError", err)
+	}
+	want := []string{fmt.Sprintf("include '%s': %v", filepath.Join(dir, "apps/["), filepath.ErrBadPattern)}
+	if !slices.Equal(verr.Problems, want) {
+		t.Errorf("Problems = %q, want %q", verr.Problems, want)
+	}
+}
+
+func TestLoadFileParseError(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFiles(t, dir, []string{"sage-conf.yaml"}, map[string]string{
+		"sage-conf.yaml":     "services: []\n",
+		"conf.d/broken.json": "{\"services\": [}\n",
+	})
+	_, err := LoadFile(path)
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("LoadFile() error = %v, want a *ParseError", err)
+	}
+	if want := filepath.Join(dir, "conf.d/broken.json"); perr.File != want || perr.Line != 1 {
+		t.Errorf("ParseError = %+v, want line 1 of %s", *perr, want)
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	// Only the struct tags of the file's format count.
+	raw := map[string]any{"stopTimeout": "1s", "stop_timeout": "1s"}
+	for _, tag := range []string{"json", "yaml", "toml"} {
+		got := unknownFields(raw, reflect.TypeOf(models.Service{}), tag, "svc")
+		if want := []string{"svc.stop_timeout: unknown field"}; !slices.Equal(got, want) {
+			t.Errorf("%s: unknownFields() = %q, want %q", tag, got, want)
+		}
+	}
+}
@@ -1,107 +1,120 @@
 package logger
 
 import (
-	"fmt"
+	"context"
 	"log/slog"
-	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+	"github.com/Arihantawasthi/sage.git/internal/utils"
+)
+
+const (
+	defaultMaxSize    = 5 * 1024 * 1024 // 5MB
+	defaultMaxBackups = 3
 )
 
+// Logger writes leveled log records. args are alternating keys and values,
+// as with slog, and With returns a Logger that adds its args to every record.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+	With(args ...any) Logger
+}
+
+// SlogLogger writes the daemon log through a rotating writer, so rotation
+// never has to swap the handler. Configure can change the level, format and
+// rotation while other goroutines are logging, including through loggers
+// derived with With.
 type SlogLogger struct {
-	filePath string
-	maxSize  int64 // in case uint, type conversion would be needed when comparing with file stat
-	file     *os.File
-	handler  *slog.Logger
-	bckupNum uint8
+	*sink
+	attrs []any
 }
 
-func NewSlogLogger(relativeFilePath string) (*SlogLogger, error) {
-    homeDir, err := os.UserHomeDir()
-    if err != nil {
-        return nil, err
-    }
-    sagedDir := fmt.Sprintf("%s/.sage/saged", homeDir)
-    filePath := fmt.Sprintf("%s/%s", homeDir, relativeFilePath)
-    dir := sagedDir
-    if err := os.MkdirAll(dir, 0755); err != nil {
-        return nil, err
-    }
+type sink struct {
+	writer  *utils.RotatingWriter
+	level   slog.LevelVar
+	handler atomic.Pointer[slog.Logger]
+	// mu serializes Configure, format is what the current handler writes.
+	mu     sync.Mutex
+	format string
+}
 
-	sl := &SlogLogger{
-		filePath: filePath,
-		maxSize:  5 * 1024 * 1024, // 5MB
-		bckupNum: 0,
-	}
-	lFile, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+func NewSlogLogger(filePath string, cfg models.DaemonLog) (*SlogLogger, error) {
+	writer, err := utils.NewRotatingWriter(filePath, rotateOptions(cfg))
 	if err != nil {
 		return nil, err
 	}
-
-	sl.file = lFile
-	logger := slog.New(slog.NewTextHandler(sl.file, nil))
-
-	sl.handler = logger
-	slog.SetDefault(sl.handler)
-
+	sl := &SlogLogger{sink: &sink{writer: writer}}
+	sl.Configure(cfg)
 	return sl, nil
 }
 
-//type Logger interface {
-//	Info(msg, source, method, path, agent string, data any)
-//	Error(msg, source, method, path, agent string, data any)
-//}
-
-func (l *SlogLogger) rotateFiles() error {
-	if l.file != nil {
-		stat, err := l.file.Stat()
-		if err != nil {
-			return err
-		}
-		if stat.Size() < l.maxSize {
-			return nil
-		}
-		l.file.Close()
+// Configure applies a new daemon log configuration. The config is expected to
+// have been validated; an unknown level or format falls back to the default.
+func (l *SlogLogger) Configure(cfg models.DaemonLog) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	level := slog.LevelInfo
+	if cfg.Level != "" {
+		level.UnmarshalText([]byte(cfg.Level))
 	}
+	l.level.Set(level)
+	l.writer.SetOptions(rotateOptions(cfg))
 
-    if l.bckupNum > 2 {
-        // Think of a better way to handle this
-        return nil
-    }
-    newFilePath := fmt.Sprintf("%s.%d", l.filePath, l.bckupNum+1)
-    err := os.Rename(l.filePath, newFilePath)
-    if err != nil {
-        return err
-    }
-
-    lFile, err := os.OpenFile(l.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-    if err != nil {
-        return err
-    }
-    l.file = lFile
-    l.handler = slog.New(slog.NewTextHandler(l.file, nil))
+	format := cfg.Format
+	if format != models.LogFormatJSON {
+		format = models.LogFormatText
+	}
+	if format == l.format {
+		return
+	}
+	opts := &slog.HandlerOptions{Level: &l.level}
+	if format == models.LogFormatJSON {
+		l.handler.Store(slog.New(slog.NewJSONHandler(l.writer, opts)))
+	} else {
+		l.handler.Store(slog.New(slog.NewTextHandler(l.writer, opts)))
+	}
+	l.format = format
+}
 
-	return nil
+func (l *SlogLogger) With(args ...any) Logger {
+	return &SlogLogger{sink: l.sink, attrs: append(slices.Clip(l.attrs), args...)}
 }
 
-func (l *SlogLogger) Info(msg, source, method, path, agent string, data any) {
-    l.rotateFiles()
-	slog.Info(
-		msg,
-		"source", source,
-		"method", method,
-		"path", path,
-		"user_agent", agent,
-		"data", data,
-	)
+func (l *SlogLogger) Debug(msg string, args ...any) { l.log(slog.LevelDebug, msg, args) }
+func (l *SlogLogger) Info(msg string, args ...any)  { l.log(slog.LevelInfo, msg, args) }
+func (l *SlogLogger) Warn(msg string, args ...any)  { l.log(slog.LevelWarn, msg, args) }
+func (l *SlogLogger) Error(msg string, args ...any) { l.log(slog.LevelError, msg, args) }
+
+// log resolves the handler on every record, so that a logger derived before
+// a format change writes the new format.
+func (l *SlogLogger) log(level slog.Level, msg string, args []any) {
+	ctx := context.Background()
+	handler := l.handler.Load()
+	if !handler.Enabled(ctx, level) {
+		return
+	}
+	if len(l.attrs) > 0 {
+		args = append(slices.Clip(l.attrs), args...)
+	}
+	handler.Log(ctx, level, msg, args...)
 }
 
-func (l *SlogLogger) Error(msg, source, method, path, agent string, data any) {
-    l.rotateFiles()
-	slog.Error(
-		msg,
-		"source", source,
-		"method", method,
-		"path", path,
-		"user_agent", agent,
-		"data", data,
-	)
+func rotateOptions(cfg models.DaemonLog) utils.RotateOptions {
+	opts := utils.RotateOptions{
+		MaxSize:    defaultMaxSize,
+		MaxBackups: defaultMaxBackups,
+	}
+	if cfg.MaxSize != nil {
+		opts.MaxSize = int64(*cfg.MaxSize)
+	}
+	if cfg.MaxBackups != nil {
+		opts.MaxBackups = *cfg.MaxBackups
+	}
+	return opts
 }
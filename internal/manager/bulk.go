@@ -0,0 +1,109 @@
+package manager
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/Arihantawasthi/sage.git/internal/config"
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+const defaultMaxParallel = 4
+
+// StartServices starts the named services and their dependencies, running up
+// to MaxParallel starts at once. A service only starts after all of its
+// dependencies have; if one of them failed it is skipped.
+func (ps *ProcessStore) StartServices(names []string) ([]models.ServiceResult, error) {
+	cfg := ps.Config()
+	order, err := config.StartOrder(cfg.ServiceMap, names)
+	if err != nil {
+		return nil, err
+	}
+
+	waitFor := func(name string) []string {
+		return cfg.ServiceMap[name].DependsOn
+	}
+	results := ps.runOrdered(order, waitFor, func(name, failedDep string) models.ServiceResult {
+		if failedDep != "" {
+			return models.ServiceResult{Name: name, Status: models.ResultSkipped, Msg: "dependency '" + failedDep + "' failed to start"}
+		}
+		message, err := ps.startService(name)
+		if err != nil {
+			return models.ServiceResult{Name: name, Status: models.ResultFailed, Msg: err.Error()}
+		}
+		return models.ServiceResult{Name: name, Status: models.ResultOK, Msg: message}
+	})
+	return results, nil
+}
+
+// StopServices stops the named services and everything depending on them,
+// running up to MaxParallel stops at once. A service is only stopped once
+// every service depending on it has been.
+func (ps *ProcessStore) StopServices(names []string) ([]models.ServiceResult, error) {
+	cfg := ps.Config()
+	order, err := config.StopOrder(cfg.ServiceMap, names)
+	if err != nil {
+		return nil, err
+	}
+
+	dependents := make(map[string][]string)
+	for _, name := range order {
+		for _, dep := range cfg.ServiceMap[name].DependsOn {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+	waitFor := func(name string) []string {
+		return dependents[name]
+	}
+	results := ps.runOrdered(order, waitFor, func(name, _ string) models.ServiceResult {
+		if !ps.IsRunning(name) {
+			return models.ServiceResult{Name: name, Status: models.ResultSkipped, Msg: "not running"}
+		}
+		return models.ServiceResult{Name: name, Status: models.ResultOK, Msg: ps.stopService(name)}
+	})
+	return results, nil
+}
+
+// runOrdered runs fn for every service in order, each one after the services
+// returned by waitFor have finished, with at most MaxParallel running at a
+// time. fn is told the first of those services that did not succeed.
+func (ps *ProcessStore) runOrdered(order []string, waitFor func(string) []string, fn func(name, failed string) models.ServiceResult) []models.ServiceResult {
+	limit := ps.Config().MaxParallel
+	if limit <= 0 {
+		limit = defaultMaxParallel
+	}
+	sem := make(chan struct{}, limit)
+
+	results := make([]models.ServiceResult, len(order))
+	done := make(map[string]chan struct{}, len(order))
+	for _, name := range order {
+		done[name] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+	for i, name := range order {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[name])
+
+			failed := ""
+			for _, prev := range waitFor(name) {
+				ch, exists := done[prev]
+				if !exists {
+					continue
+				}
+				<-ch
+				if r := results[slices.Index(order, prev)]; r.Status != models.ResultOK && failed == "" {
+					failed = prev
+				}
+			}
+
+			sem <- struct{}{}
+			results[i] = fn(name, failed)
+			<-sem
+		}()
+	}
+	wg.Wait()
+	return results
+}
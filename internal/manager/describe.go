@@ -0,0 +1,61 @@
+package manager
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+// Describe returns the configured definition of a service, the file it came
+// from and what is currently running of it.
+func (ps *ProcessStore) Describe(serviceName string) (models.ServiceInfo, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	service, exists := ps.cfg.ServiceMap[serviceName]
+	if !exists {
+		return models.ServiceInfo{}, fmt.Errorf("'%s': service name doesn't exist", serviceName)
+	}
+
+	info := models.ServiceInfo{
+		Service: service,
+		Source:  ps.cfg.Sources[serviceName],
+		Status:  models.StatusOffline,
+	}
+	if rp, exists := ps.store[serviceName]; exists {
+		info.Status = rp.Status
+		if isRunning(rp) {
+			info.Pid = rp.Pid
+			info.Outdated = !reflect.DeepEqual(rp.Service, service)
+		}
+	}
+	return info, nil
+}
+
+// Status returns the state of a service together with the limits it runs
+// with and the current usage of its cgroup.
+func (ps *ProcessStore) Status(serviceName string) (models.ServiceStatus, error) {
+	ps.mu.RLock()
+	service, exists := ps.cfg.ServiceMap[serviceName]
+	status := models.ServiceStatus{
+		Name:   serviceName,
+		Status: models.StatusOffline,
+		Limits: service.Limits,
+	}
+	if rp, running := ps.store[serviceName]; running {
+		exists = true
+		status.Status = rp.Status
+		if isRunning(rp) {
+			status.Pid = rp.Pid
+			status.UpTime = rp.UpTime
+			status.Limits = rp.Service.Limits
+		}
+	}
+	ps.mu.RUnlock()
+	if !exists {
+		return models.ServiceStatus{}, fmt.Errorf("'%s': service name doesn't exist", serviceName)
+	}
+
+	status.Cgroup, status.Usage = ps.cgroupUsage(serviceName)
+	return status, nil
+}
@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// HoldCommand as the first argument makes saged run as a pipe holder, see
+// HoldPipes.
+const HoldCommand = "hold-pipes"
+
+// Fds at which a pipe holder inherits the read ends of a service's stdout and
+// stderr pipes.
+const (
+	stdoutReaderFd = 3
+	stderrReaderFd = 4
+)
+
+// pipeSize is what the pipes of a service are grown to, so that it can keep
+// writing for a while when nobody reads its output.
+const pipeSize = 1024 * 1024
+
+// HoldPipes is what a pipe holder runs. A holder keeps a second set of read
+// ends of a service's output pipes open, so that the service doesn't get
+// SIGPIPE while the daemon is down and a restarted daemon can reopen them
+// from /proc. It never reads from them and exits once every writer of both
+// pipes is gone.
+func HoldPipes() {
+	signal.Ignore(syscall.SIGHUP, syscall.SIGINT)
+	fds := []unix.PollFd{{Fd: stdoutReaderFd}, {Fd: stderrReaderFd}}
+	open := len(fds)
+	for open > 0 {
+		// Without any events asked for, poll only reports the hangup.
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			os.Exit(1)
+		}
+		for i := range fds {
+			if fds[i].Fd >= 0 && fds[i].Revents&(unix.POLLHUP|unix.POLLERR|unix.POLLNVAL) != 0 {
+				fds[i].Fd = -1
+				open--
+			}
+		}
+	}
+}
+
+// startHolder starts a pipe holder for the read ends of a service's output
+// pipes in a session of its own, so that it outlives the daemon, and returns
+// its PID.
+func startHolder(stdoutR, stderrR *os.File) (int, error) {
+	cmd := exec.Command("/proc/self/exe", HoldCommand)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.ExtraFiles = []*os.File{stdoutR, stderrR}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	go cmd.Wait()
+	return cmd.Process.Pid, nil
+}
+
+// growPipe raises the capacity of a pipe to pipeSize where the system allows
+// it. The caller must hold on to the file.
+func growPipe(f *os.File) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return
+	}
+	conn.Control(func(fd uintptr) {
+		unix.FcntlInt(fd, unix.F_SETPIPE_SZ, pipeSize)
+	})
+}
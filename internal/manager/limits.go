@@ -0,0 +1,270 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/logger"
+	"github.com/Arihantawasthi/sage.git/internal/models"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	// cpuPeriod is the cpu.max period the CPU quota is expressed in.
+	cpuPeriod = 100000
+)
+
+var cgroupControllers = []string{"cpu", "memory", "pids"}
+
+// applyRlimits sets the rlimits of a freshly started process. They can only
+// be set from the outside once the process exists, so anything it forks in
+// the first instants keeps the daemon's limits.
+func applyRlimits(pid int, limits *models.Limits) error {
+	if limits == nil {
+		return nil
+	}
+	set := func(resource int, name string, v uint64) error {
+		if err := unix.Prlimit(pid, resource, &unix.Rlimit{Cur: v, Max: v}, nil); err != nil {
+			return fmt.Errorf("setting %s limit: %w", name, err)
+		}
+		return nil
+	}
+	if limits.NoFile > 0 {
+		if err := set(unix.RLIMIT_NOFILE, "nofile", limits.NoFile); err != nil {
+			return err
+		}
+	}
+	if limits.NProc > 0 {
+		if err := set(unix.RLIMIT_NPROC, "nproc", limits.NProc); err != nil {
+			return err
+		}
+	}
+	if limits.Core != nil {
+		if err := set(unix.RLIMIT_CORE, "core", uint64(*limits.Core)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cgroupTree is the cgroup v2 sub-tree the daemon puts services in:
+//
+//	<daemon's cgroup>/daemon         the daemon itself
+//	<daemon's cgroup>/services/<n>   one cgroup per service
+//
+// The daemon has to leave its own cgroup because cgroup v2 only lets a
+// cgroup without processes hand controllers down to its children. It is set
+// up the first time a service needs it.
+type cgroupTree struct {
+	log  logger.Logger
+	mu   sync.Mutex
+	done bool
+	base string
+	err  error
+}
+
+func (t *cgroupTree) setup() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.done {
+		t.done = true
+		t.base, t.err = setupCgroupTree()
+		if t.err != nil {
+			t.log.Warn("cgroup limits unavailable", "err", t.err)
+		}
+	}
+	return t.err
+}
+
+// serviceDir is the cgroup of a service, or "" while there is no tree.
+func (t *cgroupTree) serviceDir(serviceName string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.base == "" {
+		return ""
+	}
+	return filepath.Join(t.base, "services", serviceName)
+}
+
+func setupCgroupTree() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("no cgroup v2 hierarchy at %s", cgroupRoot)
+	}
+	own, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+	base := filepath.Join(cgroupRoot, own)
+	// A restarted daemon outside systemd may still be in the leaf of the
+	// previous one.
+	if filepath.Base(base) == "daemon" {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(base), "services")); err == nil {
+			base = filepath.Dir(base)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(base, "daemon"), 0755); err != nil {
+		return "", err
+	}
+	if err := writeCgroupFile(filepath.Join(base, "daemon"), "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return "", err
+	}
+	if err := enableControllers(base); err != nil {
+		return "", err
+	}
+	services := filepath.Join(base, "services")
+	if err := os.MkdirAll(services, 0755); err != nil {
+		return "", err
+	}
+	if err := enableControllers(services); err != nil {
+		return "", err
+	}
+	return base, nil
+}
+
+func ownCgroup() (string, error) {
+	b, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("not in a cgroup v2 hierarchy")
+}
+
+func enableControllers(dir string) error {
+	b, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	available := strings.Fields(string(b))
+	var enable []string
+	for _, c := range cgroupControllers {
+		for _, a := range available {
+			if a == c {
+				enable = append(enable, "+"+c)
+			}
+		}
+	}
+	if len(enable) == 0 {
+		return nil
+	}
+	return writeCgroupFile(dir, "cgroup.subtree_control", strings.Join(enable, " "))
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", filepath.Join(dir, name), err)
+	}
+	return nil
+}
+
+// serviceCgroup returns the cgroup a service with cgroup limits runs in,
+// creating it and writing its limits, or "" when it has none. The cgroup
+// isn't created when the tree can't be set up; the service then runs without
+// those limits.
+func (ps *ProcessStore) serviceCgroup(serviceName string, limits *models.Limits) (string, error) {
+	if !limits.NeedsCgroup() || ps.cgroups.setup() != nil {
+		return "", nil
+	}
+	dir := ps.cgroups.serviceDir(serviceName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	memory, cpu, pids := "max", "max", "max"
+	if limits.Memory > 0 {
+		memory = strconv.FormatUint(uint64(limits.Memory), 10)
+	}
+	if limits.CPU > 0 {
+		cpu = fmt.Sprintf("%d %d", int64(limits.CPU*cpuPeriod), cpuPeriod)
+	}
+	if limits.Pids > 0 {
+		pids = strconv.FormatInt(limits.Pids, 10)
+	}
+	for file, value := range map[string]string{"memory.max": memory, "cpu.max": cpu, "pids.max": pids} {
+		if err := writeCgroupFile(dir, file, value); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// removeCgroup deletes the service's cgroup once everything in it is gone.
+func (ps *ProcessStore) removeCgroup(serviceName string) {
+	dir := ps.cgroups.serviceDir(serviceName)
+	if dir == "" {
+		return
+	}
+	for i := 0; i < 10; i++ {
+		err := os.Remove(dir)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// cgroupUsage reads the current usage of the service's cgroup, or returns
+// nil when it doesn't have one.
+func (ps *ProcessStore) cgroupUsage(serviceName string) (string, *models.CgroupUsage) {
+	dir := ps.cgroups.serviceDir(serviceName)
+	if dir == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return "", nil
+	}
+
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(b))
+	}
+	readUint := func(name string) uint64 {
+		v, _ := strconv.ParseUint(read(name), 10, 64)
+		return v
+	}
+	usage := &models.CgroupUsage{
+		MemoryCurrent: models.ByteSize(readUint("memory.current")),
+		MemoryPeak:    models.ByteSize(readUint("memory.peak")),
+		MemoryMax:     read("memory.max"),
+		CPUMax:        read("cpu.max"),
+		PidsCurrent:   readUint("pids.current"),
+		PidsMax:       read("pids.max"),
+		OOMKills:      keyedValue(filepath.Join(dir, "memory.events"), "oom_kill"),
+	}
+	usage.CPUTime = models.Duration{Duration: time.Duration(keyedValue(filepath.Join(dir, "cpu.stat"), "usage_usec")) * time.Microsecond}
+	return dir, usage
+}
+
+// keyedValue reads a value from a cgroup file of "key value" lines.
+func keyedValue(path, key string) uint64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k, v, _ := strings.Cut(scanner.Text(), " ")
+		if k == key {
+			n, _ := strconv.ParseUint(v, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,151 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+	"github.com/Arihantawasthi/sage.git/internal/utils"
+)
+
+// Logs passes the lines of a service's log that req asks for to send and,
+// with req.Follow, keeps passing new ones as they are written until ctx is
+// done or send fails.
+func (ps *ProcessStore) Logs(ctx context.Context, req models.LogsRequest, send func([]string) error) error {
+	if _, exists := ps.Config().ServiceMap[req.Service]; !exists {
+		return fmt.Errorf("'%s': service name doesn't exist", req.Service)
+	}
+	logPath, err := ps.serviceLogPath(req.Service)
+	if err != nil {
+		return err
+	}
+
+	// A line without a time of its own belongs with the one before it.
+	var last time.Time
+	keep := func(line string) bool {
+		l, _ := utils.ParseLogLine(line)
+		if !l.Time.IsZero() {
+			last = l.Time
+		}
+		if req.Stream != "" && l.Stream != req.Stream {
+			return false
+		}
+		return req.Since.IsZero() || !last.Before(req.Since)
+	}
+
+	var offset int64
+	f, err := os.Open(logPath)
+	switch {
+	case err == nil:
+		lines, err := utils.TailLines(f, req.Lines, keep)
+		offset, _ = f.Seek(0, io.SeekCurrent)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if len(lines) > 0 {
+			if err := send(lines); err != nil {
+				return err
+			}
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	if !req.Follow {
+		return nil
+	}
+	return utils.FollowFile(ctx, logPath, offset, func(lines []string) error {
+		var kept []string
+		for _, line := range lines {
+			if keep(line) {
+				kept = append(kept, line)
+			}
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return send(kept)
+	})
+}
+
+// logWriter returns the writer for the service's log, opening it the first
+// time and bringing its rotation in line with the service's definition. Both
+// streams of every run share it.
+func (ps *ProcessStore) logWriter(serviceName string, service models.Service) (*utils.RotatingWriter, error) {
+	ps.logsMu.Lock()
+	defer ps.logsMu.Unlock()
+	opts := rotateOptions(service.Logs)
+	if w, exists := ps.logs[serviceName]; exists {
+		w.SetOptions(opts)
+		return w, nil
+	}
+	logPath, err := ps.serviceLogPath(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	w, err := utils.NewRotatingWriter(logPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	log := ps.serviceLog(serviceName)
+	w.SetErrorHandler(func(err error) {
+		log.Error("log rotation failed", "err", err)
+	})
+	ps.logs[serviceName] = w
+	return w, nil
+}
+
+// startLog starts a new log for a service that is started, rotating the old
+// one away when rotation is set up and emptying it otherwise. Restarts by the
+// supervisor keep appending, so the output of a crash is kept, and so do the
+// runs of a scheduled service, which share one log.
+func (ps *ProcessStore) startLog(serviceName string, service models.Service) error {
+	w, err := ps.logWriter(serviceName, service)
+	if err != nil {
+		return fmt.Errorf("error opening the log of %s: %v", serviceName, err)
+	}
+	if service.Schedule != "" {
+		return nil
+	}
+	if opts := rotateOptions(service.Logs); opts.MaxSize > 0 || opts.Daily {
+		err = w.Rotate()
+	} else {
+		err = w.Truncate()
+	}
+	if err != nil {
+		return fmt.Errorf("error starting a new log for %s: %v", serviceName, err)
+	}
+	return nil
+}
+
+// streamLogs copies a stream of a service's output into its log.
+func (ps *ProcessStore) streamLogs(pipe io.ReadCloser, src utils.LogSource, format string, out io.Writer) {
+	if err := utils.StreamLogs(pipe, src, format, out); err != nil {
+		ps.serviceLog(src.Service).Error("failed to copy output to the log", "stream", src.Stream, "pid", src.Pid, "err", err)
+	}
+}
+
+func logFormat(service models.Service) string {
+	if service.Logs == nil || service.Logs.Format == "" {
+		return models.LogFormatText
+	}
+	return service.Logs.Format
+}
+
+func rotateOptions(cfg *models.LogConfig) utils.RotateOptions {
+	if cfg == nil {
+		return utils.RotateOptions{}
+	}
+	return utils.RotateOptions{
+		MaxSize:    int64(cfg.MaxSize),
+		Daily:      cfg.Daily,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAge.Duration,
+		Compress:   cfg.Compress,
+	}
+}
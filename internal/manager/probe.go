@@ -0,0 +1,219 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/config"
+	"github.com/Arihantawasthi/sage.git/internal/models"
+	"github.com/Arihantawasthi/sage.git/internal/utils"
+)
+
+const (
+	defaultProbeInterval = 1 * time.Second
+	defaultProbeTimeout  = 1 * time.Second
+	defaultStartTimeout  = 30 * time.Second
+	// defaultStartGrace is how long a service without a readiness probe has
+	// to stay up before it is reported as started.
+	defaultStartGrace = 1 * time.Second
+
+	defaultLivenessInterval = 10 * time.Second
+	defaultFailureThreshold = 3
+)
+
+// runProbe makes a single probe attempt and returns why it failed, if it did.
+func runProbe(ctx context.Context, p models.Probe, service models.Service, logPath string) error {
+	timeout := p.Timeout.Duration
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch p.Type {
+	case models.ProbeTCP:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", p.Address)
+		if err != nil {
+			return fmt.Errorf("tcp probe %s: %w", p.Address, err)
+		}
+		conn.Close()
+		return nil
+
+	case models.ProbeHTTP:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+		if err != nil {
+			return fmt.Errorf("http probe %s: %w", p.URL, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("http probe %s: %w", p.URL, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("http probe %s: status %s", p.URL, resp.Status)
+		}
+		return nil
+
+	case models.ProbeExec:
+		if len(p.Command) == 0 {
+			return fmt.Errorf("exec probe: no command given")
+		}
+		cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+		cmd.Dir = service.WorkingDir
+		cred, _, err := config.LookupCredential(service)
+		if err != nil {
+			return fmt.Errorf("exec probe: %v", err)
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			if output := strings.TrimSpace(string(out)); output != "" {
+				err = fmt.Errorf("%v: %s", err, output)
+			}
+			return fmt.Errorf("exec probe '%s': %v", strings.Join(p.Command, " "), err)
+		}
+		return nil
+
+	case models.ProbeLog:
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("log probe: invalid pattern '%s': %w", p.Pattern, err)
+		}
+		f, err := os.Open(logPath)
+		if err != nil {
+			return fmt.Errorf("log probe: %w", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// Match what the service wrote, not the time, stream and name
+			// around it or its escaping in a JSON line.
+			l, _ := utils.ParseLogLine(scanner.Text())
+			if re.MatchString(l.Msg) {
+				return nil
+			}
+		}
+		return fmt.Errorf("log probe: no line matching '%s' yet", p.Pattern)
+
+	default:
+		return fmt.Errorf("unknown probe type '%s'", p.Type)
+	}
+}
+
+// waitReady blocks until the service passes its readiness probe, exits, or
+// runs out of start timeout. Services without a probe only need to survive
+// a short grace period.
+func (ps *ProcessStore) waitReady(serviceName string, service models.Service, rp *models.Process, pid int) error {
+	exited := func() error {
+		ps.mu.RLock()
+		defer ps.mu.RUnlock()
+		if rp.Pid != pid || !isRunning(rp) {
+			return fmt.Errorf("service exited before becoming ready (%s)", rp.LastExit)
+		}
+		return nil
+	}
+
+	if service.Readiness == nil {
+		select {
+		case <-time.After(defaultStartGrace):
+		case <-rp.ExitChan:
+		}
+		return exited()
+	}
+
+	startTimeout := service.StartTimeout.Duration
+	if startTimeout <= 0 {
+		startTimeout = defaultStartTimeout
+	}
+	interval := service.Readiness.Interval.Duration
+	if interval <= 0 {
+		interval = defaultProbeInterval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	logPath, _ := ps.serviceLogPath(serviceName)
+	for {
+		probeErr := runProbe(ctx, *service.Readiness, service, logPath)
+		if probeErr == nil {
+			return nil
+		}
+		if err := exited(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not ready after %s: %v", startTimeout, probeErr)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// checkLiveness probes the service every interval until the run identified
+// by done ends, tracking its health and requesting a restart once the
+// failure threshold is reached if the probe asks for it.
+func (ps *ProcessStore) checkLiveness(serviceName string, service models.Service, rp *models.Process, pid int, done chan struct{}) {
+	lp := service.Liveness
+	interval := lp.Interval.Duration
+	if interval <= 0 {
+		interval = defaultLivenessInterval
+	}
+	threshold := lp.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	logPath, _ := ps.serviceLogPath(serviceName)
+	log := ps.serviceLog(serviceName).With("pid", pid)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		probeErr := runProbe(context.Background(), lp.Probe, service, logPath)
+		ps.mu.Lock()
+		if rp.Pid != pid {
+			ps.mu.Unlock()
+			return
+		}
+		if probeErr == nil {
+			failures = 0
+			rp.Health = models.HealthHealthy
+		} else {
+			failures++
+			if failures >= threshold {
+				rp.Health = models.HealthUnhealthy
+			}
+		}
+		restart := failures >= threshold && lp.Restart
+		if restart {
+			rp.RestartReason = "unhealthy"
+		}
+		ps.mu.Unlock()
+
+		if probeErr != nil {
+			log.Warn("liveness probe failed", "failures", failures, "threshold", threshold, "err", probeErr)
+		}
+		if restart {
+			ps.recordEvent(serviceName, models.EventRestart, fmt.Sprintf("liveness probe failed %d times", failures))
+			terminate(log, pid, service, done)
+			return
+		}
+	}
+}
@@ -4,111 +4,429 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/Arihantawasthi/sage.git/internal/config"
+	"github.com/Arihantawasthi/sage.git/internal/logger"
 	"github.com/Arihantawasthi/sage.git/internal/models"
 	"github.com/Arihantawasthi/sage.git/internal/utils"
 	"github.com/shirou/gopsutil/process"
+	"golang.org/x/sys/unix"
 )
 
+const recentLogLines = 10
+
 type ProcessStore struct {
-	mu    sync.RWMutex
-	cfg   models.Config
-	store map[string]*models.Process
+	log      logger.Logger
+	mu       sync.RWMutex
+	stateMu  sync.Mutex
+	reloadMu sync.Mutex
+	cfg      models.Config
+	paths    config.Paths
+	store    map[string]*models.Process
+	cgroups  cgroupTree
+	eventsMu sync.Mutex
+	events   []models.Event
+	jobs     map[string]*job
+	logsMu   sync.Mutex
+	logs     map[string]*utils.RotatingWriter
 }
 
-func NewProcessStore(config models.Config) *ProcessStore {
+func NewProcessStore(cfg models.Config, paths config.Paths, log logger.Logger) *ProcessStore {
 	return &ProcessStore{
-		cfg:   config,
-		store: make(map[string]*models.Process),
+		log:     log,
+		cfg:     cfg,
+		paths:   paths,
+		store:   make(map[string]*models.Process),
+		cgroups: cgroupTree{log: log},
+		jobs:    make(map[string]*job),
+		logs:    make(map[string]*utils.RotatingWriter),
 	}
 }
 
-func (ps *ProcessStore) StartProcess(serviceName string) string {
+// serviceLog is the logger for what happens to a service.
+func (ps *ProcessStore) serviceLog(serviceName string) logger.Logger {
+	return ps.log.With("service", serviceName)
+}
+
+// Config returns the configuration the store is currently running with.
+func (ps *ProcessStore) Config() models.Config {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.cfg
+}
+
+// StartProcess starts the service after every service it depends on, waiting
+// for each one to become ready before moving on to the next.
+func (ps *ProcessStore) StartProcess(serviceName string) (string, error) {
+	order, err := config.StartOrder(ps.Config().ServiceMap, []string{serviceName})
+	if err != nil {
+		return "", err
+	}
+
+	var messages []string
+	for _, name := range order {
+		message, err := ps.startService(name)
+		if err != nil {
+			if name != serviceName {
+				err = fmt.Errorf("dependency '%s' of '%s' failed: %w", name, serviceName, err)
+			}
+			return "", err
+		}
+		messages = append(messages, message)
+	}
+	return strings.Join(messages, "\n"), nil
+}
+
+// startService spawns the service and waits for it to become ready. When it
+// never does, the service is stopped again and the error carries the probe
+// failure along with the last lines of its log.
+func (ps *ProcessStore) startService(serviceName string) (string, error) {
+	ps.mu.Lock()
 	service := ps.cfg.ServiceMap[serviceName]
+	if rp, exists := ps.store[serviceName]; exists && isRunning(rp) {
+		ps.mu.Unlock()
+		return fmt.Sprintf("Service '%s' is already %s", serviceName, rp.Status), nil
+	}
+	rp := &models.Process{
+		Name:     serviceName,
+		PName:    serviceName,
+		Cmd:      service.Command,
+		Status:   models.StatusOnline,
+		UpTime:   "0s",
+		Health:   initialHealth(service),
+		Service:  service,
+		StopChan: make(chan struct{}),
+		ExitChan: make(chan struct{}),
+	}
+	ps.store[serviceName] = rp
+	ps.mu.Unlock()
+
+	var run *serviceRun
+	err := ps.startLog(serviceName, service)
+	if err == nil {
+		run, err = ps.spawn(serviceName, service)
+	}
+	if err != nil {
+		ps.mu.Lock()
+		if ps.store[serviceName] == rp {
+			delete(ps.store, serviceName)
+		}
+		ps.mu.Unlock()
+		return "", err
+	}
+
+	pid := run.pid
+	ps.mu.Lock()
+	setRun(rp, run)
+	ps.mu.Unlock()
+	ps.saveState()
+
+	go ps.supervise(serviceName, service, rp, run)
+
+	message := fmt.Sprintf("Service '%s' started successfully with PID %d", serviceName, pid)
+	// A scheduled service is a one-shot job that may well be done before
+	// the grace period is over.
+	if service.Schedule != "" && service.Readiness == nil {
+		return message, nil
+	}
+	if err := ps.waitReady(serviceName, service, rp, pid); err != nil {
+		ps.stopService(serviceName)
+		e := fmt.Errorf("service '%s' failed to start: %v", serviceName, err)
+		logPath, _ := ps.serviceLogPath(serviceName)
+		if lines, _ := utils.TailFile(logPath, recentLogLines); len(lines) > 0 {
+			e = fmt.Errorf("%v\nrecent log output:\n%s", e, strings.Join(lines, "\n"))
+		}
+		return "", e
+	}
+	return message, nil
+}
+
+func (ps *ProcessStore) spawn(serviceName string, service models.Service) (*serviceRun, error) {
 	cmd := exec.Command(service.Command, service.Args...)
+	// A new session makes the service the leader of its own process group,
+	// so signals sent to -pid reach every worker it forks.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
     cmd.Env = os.Environ()
     cmd.Dir = service.WorkingDir
-    for k, v := range ps.cfg.ServiceMap[serviceName].Env {
+    cred, usr, err := config.LookupCredential(service)
+    if err != nil {
+        return nil, fmt.Errorf("error starting the process: %v", err)
+    }
+    cmd.SysProcAttr.Credential = cred
+    cgroupDir, err := ps.serviceCgroup(serviceName, service.Limits)
+    if err != nil {
+        return nil, fmt.Errorf("error setting up the cgroup: %v", err)
+    }
+    if cgroupDir != "" {
+        // Starting the service right inside its cgroup leaves no window in
+        // which it or its children escape the limits.
+        cgroupFd, err := unix.Open(cgroupDir, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+        if err != nil {
+            return nil, fmt.Errorf("error opening the cgroup: %v", err)
+        }
+        defer unix.Close(cgroupFd)
+        cmd.SysProcAttr.UseCgroupFD = true
+        cmd.SysProcAttr.CgroupFD = cgroupFd
+    }
+    if usr != nil {
+        cmd.Env = append(cmd.Env, "HOME="+usr.HomeDir, "USER="+usr.Username, "LOGNAME="+usr.Username)
+    }
+    for k, v := range service.Env {
         envVar := fmt.Sprintf("%s=%s", k, v)
         cmd.Env = append(cmd.Env, envVar)
     }
 
-    stdout, err := cmd.StdoutPipe()
+    logWriter, err := ps.logWriter(serviceName, service)
     if err != nil {
-        return fmt.Sprintf("failed to get stdout pipe: %v", err)
+        return nil, fmt.Errorf("error starting the process: %v", err)
     }
-    stderr, err := cmd.StderrPipe()
+
+    stdoutR, stdoutW, err := os.Pipe()
     if err != nil {
-        return fmt.Sprintf("failed to get stderr pipe: %v", err)
+        return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
     }
-
-    lDir, err := utils.CreateServiceLogDir()
-    serviceLogPath := fmt.Sprintf("%s/%s.log", lDir, serviceName)
+    stderrR, stderrW, err := os.Pipe()
     if err != nil {
-        e := fmt.Errorf("error starting the process: %v\n" ,err)
-        return e.Error()
+        stdoutR.Close()
+        stdoutW.Close()
+        return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
     }
-    if _, err := os.Stat(serviceLogPath); err == nil {
-        _, err := os.Create(serviceLogPath)
-        if err != nil {
-            e := fmt.Errorf("error creating log file for %s", serviceName)
-            return e.Error()
-        }
+    cmd.Stdout = stdoutW
+    cmd.Stderr = stderrW
+    growPipe(stdoutR)
+    growPipe(stderrR)
+    // The holder is there before the service writes anything, so there is
+    // no window in which a daemon restart leaves the pipes without a reader.
+    holder, holderErr := startHolder(stdoutR, stderrR)
+
+	err = cmd.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdoutR.Close()
+		stderrR.Close()
+		return nil, fmt.Errorf("error starting the process: %v", err)
+	}
+
+    if err := applyRlimits(cmd.Process.Pid, service.Limits); err != nil {
+        syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+        cmd.Wait()
+        stdoutR.Close()
+        stderrR.Close()
+        return nil, fmt.Errorf("error starting the process: %v", err)
     }
 
-    go utils.StreamLogs(stdout, fmt.Sprintf("[stdout][%s]", serviceName), serviceLogPath)
-    go utils.StreamLogs(stderr, fmt.Sprintf("[stderr][%s]", serviceName), serviceLogPath)
+    format := logFormat(service)
+    go ps.streamLogs(stdoutR, utils.LogSource{Service: serviceName, Stream: models.StreamStdout, Pid: cmd.Process.Pid}, format, logWriter)
+    go ps.streamLogs(stderrR, utils.LogSource{Service: serviceName, Stream: models.StreamStderr, Pid: cmd.Process.Pid}, format, logWriter)
 
-	err = cmd.Start()
+	log := ps.serviceLog(serviceName).With("pid", cmd.Process.Pid)
+	log.Info("process started")
+	createTime, err := processCreateTime(cmd.Process.Pid)
 	if err != nil {
-		e := fmt.Errorf("error starting the process: %v\n", err)
-		return e.Error()
+		log.Warn("failed to get the process create time", "err", err)
 	}
+	run := &serviceRun{pid: cmd.Process.Pid, createTime: createTime, wait: cmd.Wait}
+	if holderErr != nil {
+		log.Warn("failed to start the pipe holder, output is lost while the daemon is down", "err", holderErr)
+	} else {
+		run.holder = holder
+		run.holderCreateTime, _ = processCreateTime(holder)
+	}
+	return run, nil
+}
+
+// supervise waits for the service to exit and respawns it according to its
+// restart policy until it is stopped or runs out of retries.
+func (ps *ProcessStore) supervise(serviceName string, service models.Service, rp *models.Process, run *serviceRun) {
+	defer close(rp.ExitChan)
+	defer ps.saveState()
+	log := ps.serviceLog(serviceName)
+	var restarts []time.Time
+	var spawnErr error
+	for {
+		exitErr := spawnErr
+		if run != nil {
+			done := make(chan struct{})
+			go ps.monitorProcess(serviceName, service, rp, run.pid, rp.StopChan, done)
+			if service.Liveness != nil {
+				go ps.checkLiveness(serviceName, service, rp, run.pid, done)
+			}
+			exitErr = run.wait()
+			close(done)
+			// Reap whatever the leader left behind in its process group.
+			syscall.Kill(-run.pid, syscall.SIGKILL)
+			ps.removeCgroup(serviceName)
+			log.Info("process exited", "pid", run.pid, "reason", exitReason(exitErr))
+		}
 
-	pid := cmd.Process.Pid
+		ps.mu.Lock()
+		rp.LastExit = exitReason(exitErr)
+		rp.ExitCode = exitCode(exitErr)
+		rp.ExitedAt = time.Now()
+		forced := rp.RestartReason != ""
+		if forced {
+			rp.LastExit = fmt.Sprintf("%s, %s", rp.RestartReason, rp.LastExit)
+		}
+		rp.RestartReason = ""
+		resetStats(rp)
+		ps.mu.Unlock()
 
-	ps.mu.Lock()
-	stopChan := make(chan struct{})
-	ps.store[serviceName] = &models.Process{
-		Pid:      pid,
-		Name:     serviceName,
-		PName:    serviceName,
-		Cmd:      service.Command,
-		StopChan: stopChan,
+		select {
+		case <-rp.StopChan:
+			ps.setStatus(rp, models.StatusOffline)
+			return
+		default:
+		}
+
+		if !forced {
+			if !shouldRestart(service.Restart.Policy, exitErr) {
+				ps.setStatus(rp, models.StatusOffline)
+				return
+			}
+
+			now := time.Now()
+			if window := service.Restart.RetryWindow.Duration; window > 0 {
+				for len(restarts) > 0 && restarts[0].Before(now.Add(-window)) {
+					restarts = restarts[1:]
+				}
+			}
+			if service.Restart.MaxRetries > 0 && len(restarts) >= service.Restart.MaxRetries {
+				log.Error("exceeded the restart limit, giving up", "maxRetries", service.Restart.MaxRetries)
+				ps.setStatus(rp, models.StatusErrored)
+				return
+			}
+			delay := backoffDelay(service.Restart, len(restarts))
+			restarts = append(restarts, now)
+			ps.setStatus(rp, models.StatusRestarting)
+
+			select {
+			case <-time.After(delay):
+			case <-rp.StopChan:
+				ps.setStatus(rp, models.StatusOffline)
+				return
+			}
+		}
+
+		run, spawnErr = ps.spawn(serviceName, service)
+		if spawnErr != nil {
+			log.Error("failed to restart", "err", spawnErr)
+		}
+		ps.mu.Lock()
+		// A stop that came in while spawning found no PID to signal and
+		// is waiting for the supervisor to finish.
+		stopped := false
+		select {
+		case <-rp.StopChan:
+			stopped = true
+		default:
+			rp.Restarts++
+			if spawnErr == nil {
+				setRun(rp, run)
+				rp.Status = models.StatusOnline
+				rp.Health = initialHealth(service)
+			}
+		}
+		ps.mu.Unlock()
+		if stopped {
+			if spawnErr == nil {
+				ps.abortRun(serviceName, service, run)
+			}
+			ps.setStatus(rp, models.StatusOffline)
+			return
+		}
+		ps.saveState()
 	}
-	ps.mu.Unlock()
+}
 
-	go ps.monitorProcess(serviceName, pid, stopChan)
+// abortRun stops a run that was spawned after its service was asked to stop
+// and waits for it to exit.
+func (ps *ProcessStore) abortRun(serviceName string, service models.Service, run *serviceRun) {
+	log := ps.serviceLog(serviceName)
+	exited := make(chan struct{})
+	var exitErr error
 	go func() {
-		err := cmd.Wait()
-		delete(ps.store, serviceName)
-		if err != nil {
-			fmt.Printf("process %s (PID %d) exited with error: %v\n", serviceName, pid, err)
-		} else {
-			fmt.Printf("process %s (PID %d) exited normally\n", serviceName, pid)
-		}
+		exitErr = run.wait()
+		close(exited)
 	}()
+	terminate(log, run.pid, service, exited)
+	syscall.Kill(-run.pid, syscall.SIGKILL)
+	ps.removeCgroup(serviceName)
+	log.Info("process exited", "pid", run.pid, "reason", exitReason(exitErr))
+}
 
-    time.Sleep(10 * time.Second)
-    message := fmt.Sprintf("Service '%s' started successfully with PID %d\n", serviceName, pid)
-	return message
+func (ps *ProcessStore) serviceLogPath(serviceName string) (string, error) {
+	if err := utils.CreateServiceLogDir(ps.paths.LogDir); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s.log", ps.paths.LogDir, serviceName), nil
 }
 
-func (ps *ProcessStore) StopProcess(serviceName string) string {
+func (ps *ProcessStore) setStatus(rp *models.Process, status string) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
+	rp.Status = status
+}
+
+// StopProcess stops the service after stopping every running service that
+// depends on it.
+func (ps *ProcessStore) StopProcess(serviceName string) string {
+	order, err := config.StopOrder(ps.Config().ServiceMap, []string{serviceName})
+	if err != nil {
+		return err.Error()
+	}
+
+	var messages []string
+	for _, name := range order {
+		if name != serviceName && !ps.IsRunning(name) {
+			continue
+		}
+		messages = append(messages, ps.stopService(name))
+	}
+	return strings.Join(messages, "\n")
+}
+
+// IsRunning reports whether the service is up or waiting to be restarted.
+func (ps *ProcessStore) IsRunning(serviceName string) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	rp, exists := ps.store[serviceName]
+	return exists && isRunning(rp)
+}
+
+// stopService sends the service's process group its stop signal and waits up
+// to its stop timeout for it to exit before escalating to SIGKILL.
+func (ps *ProcessStore) stopService(serviceName string) string {
+	ps.mu.Lock()
 	runningProcess, exists := ps.store[serviceName]
-	if !exists {
-		e := fmt.Errorf("Service %s is not running", serviceName)
-		return e.Error()
+	if !exists || !isRunning(runningProcess) {
+		ps.mu.Unlock()
+		return fmt.Sprintf("Service %s is not running", serviceName)
 	}
 	close(runningProcess.StopChan)
-	delete(ps.store, serviceName)
+	runningProcess.Status = models.StatusStopping
+	pid := runningProcess.Pid
+	// A reload may have changed or removed the service since it started.
+	service := runningProcess.Service
+	ps.mu.Unlock()
+
+	if pid == 0 {
+		<-runningProcess.ExitChan
+		return fmt.Sprintf("Service '%s' stopped while waiting to restart", serviceName)
+	}
 
-	message := fmt.Sprintf("Service '%s' stopped successfully", serviceName)
-	return message
+	sig, timeout, killed := terminate(ps.serviceLog(serviceName), pid, service, runningProcess.ExitChan)
+	if killed {
+		return fmt.Sprintf("Service '%s' did not exit within %s and was killed with SIGKILL", serviceName, timeout)
+	}
+	ps.mu.RLock()
+	lastExit := runningProcess.LastExit
+	ps.mu.RUnlock()
+	return fmt.Sprintf("Service '%s' stopped gracefully with %s (%s)", serviceName, unix.SignalName(sig), lastExit)
 }
 
 func (ps *ProcessStore) ListProcesses(payload string) []models.PListData {
@@ -123,7 +441,7 @@ func (ps *ProcessStore) ListProcesses(payload string) []models.PListData {
 			PName:      service.Name,
 			Name:       service.Name,
 			Cmd:        service.Command,
-			Status:     "offline",
+			Status:     models.StatusOffline,
 			UpTime:     "0s",
 			CPUPercent: 0.00,
 			MemPrecent: 0.00,
@@ -131,10 +449,20 @@ func (ps *ProcessStore) ListProcesses(payload string) []models.PListData {
 
 		if exists && rp != nil {
 			data.Pid = rp.Pid
-			data.Status = "online"
+			data.Status = rp.Status
 			data.UpTime = rp.UpTime
 			data.CPUPercent = rp.CPUPercent
 			data.MemPrecent = rp.MemPrecent
+			data.Restarts = rp.Restarts
+			data.LastExit = rp.LastExit
+			data.Health = rp.Health
+		}
+		if service.Schedule != "" {
+			j, exists := ps.jobs[name]
+			if !exists {
+				j = &job{}
+			}
+			data.Job = j.status(service, rp)
 		}
 
 		plist = append(plist, data)
@@ -143,47 +471,60 @@ func (ps *ProcessStore) ListProcesses(payload string) []models.PListData {
 	return plist
 }
 
-func (ps *ProcessStore) monitorProcess(serviceName string, pid int, stopChan chan struct{}) {
+func (ps *ProcessStore) monitorProcess(serviceName string, service models.Service, rp *models.Process, pid int, stopChan, done chan struct{}) {
+	log := ps.serviceLog(serviceName).With("pid", pid)
 	proc, err := process.NewProcess(int32(pid))
 	if err != nil {
-		fmt.Errorf("failed to create process monitor for PID %d: %v", pid, err)
+		// Short runs are often gone before they can be watched.
+		log.Debug("failed to create the process monitor", "err", err)
 		return
 	}
 
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(monitorInterval)
 	defer ticker.Stop()
 
+	breaches := make([]breach, len(service.Thresholds))
+	var lastCPUTime float64
+	var lastSample time.Time
 	for {
 		select {
 		case <-ticker.C:
-			cpuPercent, err := proc.CPUPercent()
-			if err != nil {
-				fmt.Errorf("process %s (PID %d) is not running\n", serviceName, pid)
-				continue
-			}
-			memPercent, err := proc.MemoryPercent()
+			u, err := treeUsage(proc)
 			if err != nil {
-				fmt.Errorf("process %s (PID %d) is not running\n", serviceName, pid)
+				log.Debug("process is not running")
 				continue
 			}
 			createTimeMillis, err := proc.CreateTime()
 			if err != nil {
-				fmt.Errorf("failed to get the create time for process %s: %v\n", serviceName, pid)
+				log.Warn("failed to get the process create time", "err", err)
 			}
 			startTime := time.Unix(0, createTimeMillis*int64(time.Millisecond))
 			uptime := time.Since(startTime).String()
 
 			ps.mu.Lock()
 			storedProc, exists := ps.store[serviceName]
-			if exists {
-				storedProc.CPUPercent = cpuPercent
-				storedProc.MemPrecent = memPercent
+			if exists && storedProc.Pid == pid {
+				storedProc.CPUPercent = u.cpuPercent
+				storedProc.MemPrecent = u.memPercent
 				storedProc.UpTime = uptime
 			}
 			ps.mu.Unlock()
 
+			now := time.Now()
+			s := sample{memory: float64(u.memPercent), rss: models.ByteSize(u.rss)}
+			// The first sample has nothing to measure CPU usage against.
+			if !lastSample.IsZero() {
+				s.cpu = max(0, (u.cpuTime-lastCPUTime)/now.Sub(lastSample).Seconds()*100)
+			}
+			lastCPUTime, lastSample = u.cpuTime, now
+			if ps.checkThresholds(serviceName, service, rp, pid, s, breaches, done) {
+				return
+			}
+
 		case <-stopChan:
-			proc.Kill()
+			return
+
+		case <-done:
 			return
 		}
 	}
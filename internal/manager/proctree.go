@@ -0,0 +1,89 @@
+package manager
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// descendants returns every other live process in the session led by pid.
+// Services start in their own session, so workers they fork are accounted
+// for alongside them even after they are reparented by daemonizing.
+func descendants(pid int32) []*process.Process {
+	pids, err := process.Pids()
+	if err != nil {
+		return nil
+	}
+	var tree []*process.Process
+	for _, p := range pids {
+		if p == pid || sessionID(p) != pid {
+			continue
+		}
+		if proc, err := process.NewProcess(p); err == nil {
+			tree = append(tree, proc)
+		}
+	}
+	return tree
+}
+
+// sessionID reads the session of pid from field 6 of /proc/<pid>/stat, or
+// returns -1 if it can't. The fields are counted from the end of the
+// command name, which may itself hold spaces and parentheses.
+func sessionID(pid int32) int32 {
+	b, err := os.ReadFile("/proc/" + strconv.Itoa(int(pid)) + "/stat")
+	if err != nil {
+		return -1
+	}
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return -1
+	}
+	// state, ppid, pgrp, session
+	fields := bytes.Fields(b[i+1:])
+	if len(fields) < 4 {
+		return -1
+	}
+	sid, err := strconv.ParseInt(string(fields[3]), 10, 32)
+	if err != nil {
+		return -1
+	}
+	return int32(sid)
+}
+
+// usage is a sample of the resources used by a service and all of its
+// descendants.
+type usage struct {
+	// cpuPercent is averaged over each process' lifetime, as gopsutil does.
+	cpuPercent float64
+	memPercent float32
+	rss        uint64
+	// cpuTime is the user and system time in seconds, from which the
+	// monitor works out the usage between two samples.
+	cpuTime float64
+}
+
+// treeUsage sums CPU and memory usage of root and every process in its
+// session.
+func treeUsage(root *process.Process) (usage, error) {
+	var u usage
+	if _, err := root.CPUPercent(); err != nil {
+		return u, err
+	}
+	for _, p := range append([]*process.Process{root}, descendants(root.Pid)...) {
+		if c, err := p.CPUPercent(); err == nil {
+			u.cpuPercent += c
+		}
+		if m, err := p.MemoryPercent(); err == nil {
+			u.memPercent += m
+		}
+		if mem, err := p.MemoryInfo(); err == nil {
+			u.rss += mem.RSS
+		}
+		if t, err := p.Times(); err == nil {
+			u.cpuTime += t.User + t.System
+		}
+	}
+	return u, nil
+}
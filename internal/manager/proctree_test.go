@@ -0,0 +1,39 @@
+package manager
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDescendantsFollowsSession(t *testing.T) {
+	// The first sleep is orphaned right away and reparented to init, as a
+	// daemonizing worker would be.
+	cmd := exec.Command("sh", "-c", "(sleep 30 &); sleep 30; true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pid := int32(cmd.Process.Pid)
+	defer func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	}()
+
+	var count int
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		count = 0
+		for _, p := range descendants(pid) {
+			if name, err := p.Name(); err == nil && name == "sleep" {
+				count++
+			}
+		}
+		if count == 2 {
+			break
+		}
+	}
+	if count != 2 {
+		t.Errorf("descendants() has %d sleep processes, want 2", count)
+	}
+}
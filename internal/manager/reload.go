@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"reflect"
+	"slices"
+
+	"github.com/Arihantawasthi/sage.git/internal/config"
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+// Reload re-reads the config file and applies it with ApplyConfig.
+func (ps *ProcessStore) Reload(restartChanged bool) (models.ReloadResult, error) {
+	newCfg, err := config.LoadConfig(ps.paths.Config)
+	if err != nil {
+		return models.ReloadResult{}, err
+	}
+	return ps.ApplyConfig(newCfg, restartChanged), nil
+}
+
+// outdated returns the running services whose definition in cfg differs from
+// the one they were started with, including those changed by earlier reloads
+// that didn't restart them.
+func (ps *ProcessStore) outdated(cfg models.Config) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	var names []string
+	for _, name := range config.ServiceNames(cfg.ServiceMap) {
+		rp, exists := ps.store[name]
+		if exists && isRunning(rp) && !reflect.DeepEqual(rp.Service, cfg.ServiceMap[name]) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ApplyConfig switches the store over to newCfg. Running services that were
+// removed are stopped and new services marked with autostart are started.
+// Changed services keep running with their old definition unless
+// restartChanged is set, in which case every running service whose definition
+// is out of date (and whatever had to be stopped along with it) is restarted
+// with the new one.
+func (ps *ProcessStore) ApplyConfig(newCfg models.Config, restartChanged bool) models.ReloadResult {
+	ps.reloadMu.Lock()
+	defer ps.reloadMu.Unlock()
+
+	result := models.ReloadResult{ConfigDiff: config.Diff(ps.Config(), newCfg)}
+
+	var toStop []string
+	for _, name := range result.Removed {
+		if ps.IsRunning(name) {
+			toStop = append(toStop, name)
+		}
+	}
+	if restartChanged {
+		toStop = append(toStop, ps.outdated(newCfg)...)
+	}
+
+	var toStart []string
+	if len(toStop) > 0 {
+		stopped, err := ps.StopServices(toStop)
+		if err != nil {
+			result.Results = append(result.Results, models.ServiceResult{Status: models.ResultFailed, Msg: err.Error()})
+		}
+		for _, r := range stopped {
+			if r.Status != models.ResultOK {
+				continue
+			}
+			result.Results = append(result.Results, r)
+			if _, exists := newCfg.ServiceMap[r.Name]; exists {
+				toStart = append(toStart, r.Name)
+			}
+		}
+	}
+
+	ps.mu.Lock()
+	ps.cfg = newCfg
+	for name, rp := range ps.store {
+		if _, exists := newCfg.ServiceMap[name]; !exists && !isRunning(rp) {
+			delete(ps.store, name)
+		}
+	}
+	ps.mu.Unlock()
+
+	for _, name := range result.Added {
+		if newCfg.ServiceMap[name].Autostart {
+			toStart = append(toStart, name)
+		}
+	}
+	if len(toStart) > 0 {
+		started, err := ps.StartServices(toStart)
+		if err != nil {
+			result.Results = append(result.Results, models.ServiceResult{Status: models.ResultFailed, Msg: err.Error()})
+		}
+		for _, r := range started {
+			if !slices.Contains(toStart, r.Name) {
+				continue
+			}
+			if i := slices.IndexFunc(result.Results, func(prev models.ServiceResult) bool { return prev.Name == r.Name }); i >= 0 {
+				result.Results[i] = models.ServiceResult{Name: r.Name, Status: r.Status, Msg: "restarted: " + r.Msg}
+				continue
+			}
+			result.Results = append(result.Results, r)
+		}
+	}
+
+	ps.saveState()
+	return result
+}
@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultInitialDelay = 1 * time.Second
+	defaultMaxDelay     = 1 * time.Minute
+)
+
+func isRunning(rp *models.Process) bool {
+	return rp.Status == models.StatusOnline || rp.Status == models.StatusRestarting
+}
+
+func resetStats(rp *models.Process) {
+	rp.Pid = 0
+	rp.UpTime = "0s"
+	rp.CPUPercent = 0
+	rp.MemPrecent = 0
+	rp.Health = ""
+}
+
+func setRun(rp *models.Process, run *serviceRun) {
+	rp.Pid = run.pid
+	rp.CreateTime = run.createTime
+	rp.Holder = run.holder
+	rp.HolderCreateTime = run.holderCreateTime
+	rp.StartedAt = time.Now()
+}
+
+func initialHealth(service models.Service) string {
+	if service.Liveness == nil {
+		return ""
+	}
+	return models.HealthUnknown
+}
+
+func shouldRestart(policy string, exitErr error) bool {
+	switch policy {
+	case models.RestartAlways:
+		return true
+	case models.RestartOnFailure:
+		return exitErr != nil
+	default:
+		return false
+	}
+}
+
+// backoffDelay doubles the initial delay for every restart already made in
+// the current window, caps it at the max delay and adds the configured jitter.
+func backoffDelay(policy models.RestartPolicy, attempt int) time.Duration {
+	delay := policy.InitialDelay.Duration
+	if delay <= 0 {
+		delay = defaultInitialDelay
+	}
+	maxDelay := policy.MaxDelay.Duration
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxDelay
+	}
+
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if policy.Jitter > 0 {
+		delay += time.Duration(rand.Float64() * policy.Jitter * float64(delay))
+	}
+	return delay
+}
+
+func exitReason(err error) string {
+	if err == nil {
+		return "exited normally"
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return fmt.Sprintf("killed by %s", unix.SignalName(status.Signal()))
+		}
+		return fmt.Sprintf("exit code %d", exitErr.ExitCode())
+	}
+	return err.Error()
+}
+
+// exitCode is the exit code a shell would report for err, or nil when it
+// isn't known.
+func exitCode(err error) *int {
+	code := 0
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			code = 128 + int(status.Signal())
+		}
+	default:
+		return nil
+	}
+	return &code
+}
@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  models.RestartPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{name: "defaults", attempt: 0, want: defaultInitialDelay},
+		{name: "defaults doubled", attempt: 3, want: 8 * defaultInitialDelay},
+		{name: "defaults capped", attempt: 10, want: defaultMaxDelay},
+		{name: "first", policy: restartPolicy(100*time.Millisecond, time.Second), attempt: 0, want: 100 * time.Millisecond},
+		{name: "doubled", policy: restartPolicy(100*time.Millisecond, time.Second), attempt: 2, want: 400 * time.Millisecond},
+		{name: "capped", policy: restartPolicy(100*time.Millisecond, time.Second), attempt: 4, want: time.Second},
+		{name: "many attempts", policy: restartPolicy(100*time.Millisecond, time.Second), attempt: 1000, want: time.Second},
+		{name: "initial above max", policy: restartPolicy(time.Minute, time.Second), attempt: 0, want: time.Second},
+	}
+	for _, tt := range tests {
+		if got := backoffDelay(tt.policy, tt.attempt); got != tt.want {
+			t.Errorf("%s: backoffDelay(%d) = %v, want %v", tt.name, tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffDelayJitter(t *testing.T) {
+	policy := restartPolicy(time.Second, 4*time.Second)
+	policy.Jitter = 0.5
+	for attempt, base := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		for range 100 {
+			got := backoffDelay(policy, attempt)
+			if got < base || got > base+base/2 {
+				t.Fatalf("backoffDelay(%d) = %v, want within [%v, %v]", attempt, got, base, base+base/2)
+			}
+		}
+	}
+}
+
+func restartPolicy(initial, max time.Duration) models.RestartPolicy {
+	return models.RestartPolicy{
+		InitialDelay: models.Duration{Duration: initial},
+		MaxDelay:     models.Duration{Duration: max},
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	failed := errors.New("exit status 1")
+	tests := []struct {
+		policy  string
+		exitErr error
+		want    bool
+	}{
+		{"", nil, false},
+		{"", failed, false},
+		{models.RestartNever, failed, false},
+		{models.RestartOnFailure, nil, false},
+		{models.RestartOnFailure, failed, true},
+		{models.RestartAlways, nil, true},
+		{models.RestartAlways, failed, true},
+	}
+	for _, tt := range tests {
+		if got := shouldRestart(tt.policy, tt.exitErr); got != tt.want {
+			t.Errorf("shouldRestart(%q, %v) = %v, want %v", tt.policy, tt.exitErr, got, tt.want)
+		}
+	}
+}
+
+func TestExitReasonAndCode(t *testing.T) {
+	run := func(script string) error {
+		return exec.Command("sh", "-c", script).Run()
+	}
+	tests := []struct {
+		name   string
+		err    error
+		reason string
+		code   int
+		noCode bool
+	}{
+		{name: "success", err: run("exit 0"), reason: "exited normally", code: 0},
+		{name: "exit code", err: run("exit 3"), reason: "exit code 3", code: 3},
+		{name: "SIGTERM", err: run("kill -TERM $$"), reason: "killed by SIGTERM", code: 128 + 15},
+		{name: "SIGKILL", err: run("kill -KILL $$"), reason: "killed by SIGKILL", code: 128 + 9},
+		{name: "not an exit", err: errors.New("fork/exec /nope: no such file or directory"), reason: "fork/exec /nope: no such file or directory", noCode: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitReason(tt.err); got != tt.reason {
+				t.Errorf("exitReason() = %q, want %q", got, tt.reason)
+			}
+			got := exitCode(tt.err)
+			switch {
+			case tt.noCode && got != nil:
+				t.Errorf("exitCode() = %d, want nil", *got)
+			case !tt.noCode && (got == nil || *got != tt.code):
+				t.Errorf("exitCode() = %v, want %d", got, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/config"
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+const schedulerInterval = time.Second
+
+// job is the scheduling state of a service with a schedule. The outcome of
+// its last run is read from its process in the store, so runs started by
+// hand count too.
+type job struct {
+	expr     string
+	schedule config.Schedule
+	next     time.Time
+	// starting is set while a run is being launched, queued while a run
+	// waits for the previous one to exit.
+	starting bool
+	queued   bool
+}
+
+// RunScheduler launches scheduled services when they are due until ctx is
+// done. Schedules are picked up from the current config on every tick, so
+// reloads apply without restarting it.
+func (ps *ProcessStore) RunScheduler(ctx context.Context) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ps.runDueJobs(now)
+		}
+	}
+}
+
+// runDueJobs launches every scheduled service that is due, or has a queued
+// run and nothing running, applying its overlap policy when its previous run
+// is still going.
+func (ps *ProcessStore) runDueJobs(now time.Time) {
+	type launch struct {
+		name    string
+		replace bool
+	}
+	var due []launch
+
+	ps.mu.Lock()
+	for name := range ps.jobs {
+		if ps.cfg.ServiceMap[name].Schedule == "" {
+			delete(ps.jobs, name)
+		}
+	}
+	for name, service := range ps.cfg.ServiceMap {
+		if service.Schedule == "" {
+			continue
+		}
+		j, exists := ps.jobs[name]
+		if !exists {
+			j = &job{}
+			ps.jobs[name] = j
+		}
+		if j.expr != service.Schedule {
+			sched, err := config.ParseSchedule(service.Schedule)
+			if err != nil {
+				ps.serviceLog(name).Error("invalid schedule", "schedule", service.Schedule, "err", err)
+				continue
+			}
+			j.expr, j.schedule, j.next = service.Schedule, sched, sched.Next(now)
+		}
+
+		rp := ps.store[name]
+		busy := j.starting || rp != nil && rp.Status != models.StatusOffline && rp.Status != models.StatusErrored
+		if j.queued && !busy {
+			j.queued = false
+			j.starting = true
+			busy = true
+			due = append(due, launch{name: name})
+		}
+		if j.next.IsZero() || now.Before(j.next) {
+			continue
+		}
+		j.next = j.schedule.Next(now)
+		if !busy {
+			j.starting = true
+			due = append(due, launch{name: name})
+			continue
+		}
+
+		switch service.Overlap {
+		case models.OverlapQueue:
+			if !j.queued {
+				j.queued = true
+				ps.jobEvent(name, "run queued until the previous one exits")
+			}
+		case models.OverlapReplace:
+			if !j.starting {
+				j.starting = true
+				due = append(due, launch{name: name, replace: true})
+			}
+		default:
+			ps.jobEvent(name, "run skipped, the previous one is still going")
+		}
+	}
+	ps.mu.Unlock()
+
+	for _, l := range due {
+		go ps.runJob(l.name, l.replace)
+	}
+}
+
+// runJob starts a scheduled service along with its dependencies, stopping
+// its previous run first when replacing it.
+func (ps *ProcessStore) runJob(serviceName string, replace bool) {
+	if replace && ps.IsRunning(serviceName) {
+		ps.jobEvent(serviceName, "previous run replaced: "+ps.stopService(serviceName))
+	}
+	message, err := ps.StartProcess(serviceName)
+
+	ps.mu.Lock()
+	if j, exists := ps.jobs[serviceName]; exists {
+		j.starting = false
+	}
+	ps.mu.Unlock()
+
+	if err != nil {
+		ps.jobEvent(serviceName, fmt.Sprintf("scheduled run failed: %v", err))
+		return
+	}
+	ps.serviceLog(serviceName).Info("scheduled run started", "result", message)
+}
+
+func (ps *ProcessStore) jobEvent(serviceName, msg string) {
+	ps.serviceLog(serviceName).Info(msg)
+	ps.recordEvent(serviceName, models.EventSchedule, msg)
+}
+
+// status reports the job along with the last run of its service, if any.
+// The caller must hold ps.mu.
+func (j *job) status(service models.Service, rp *models.Process) *models.JobStatus {
+	status := &models.JobStatus{
+		Schedule: service.Schedule,
+		Overlap:  service.Overlap,
+		NextRun:  j.next,
+		Queued:   j.queued,
+	}
+	if status.Overlap == "" {
+		status.Overlap = models.OverlapSkip
+	}
+	if rp == nil || rp.StartedAt.IsZero() {
+		return status
+	}
+	status.LastRun = rp.StartedAt
+	if isRunning(rp) || rp.Status == models.StatusStopping {
+		status.Duration.Duration = time.Since(rp.StartedAt).Truncate(time.Second)
+		return status
+	}
+	if rp.ExitedAt.After(rp.StartedAt) {
+		status.Duration.Duration = rp.ExitedAt.Sub(rp.StartedAt).Truncate(time.Millisecond)
+	}
+	status.ExitCode = rp.ExitCode
+	return status
+}
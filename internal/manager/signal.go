@@ -0,0 +1,40 @@
+package manager
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/config"
+	"github.com/Arihantawasthi/sage.git/internal/logger"
+	"github.com/Arihantawasthi/sage.git/internal/models"
+	"golang.org/x/sys/unix"
+)
+
+const defaultStopTimeout = 10 * time.Second
+
+// terminate sends the service's stop signal to the process group led by pid
+// and escalates to SIGKILL when exited isn't closed within the stop timeout.
+// Failures go to log, the logger of the service.
+func terminate(log logger.Logger, pid int, service models.Service, exited <-chan struct{}) (syscall.Signal, time.Duration, bool) {
+	sig, err := config.ParseSignal(service.StopSignal)
+	if err != nil {
+		sig = syscall.SIGTERM
+	}
+	timeout := service.StopTimeout.Duration
+	if timeout <= 0 {
+		timeout = defaultStopTimeout
+	}
+
+	if err := syscall.Kill(-pid, sig); err != nil {
+		log.Warn("failed to send the stop signal", "pid", pid, "signal", unix.SignalName(sig), "err", err)
+	}
+	select {
+	case <-exited:
+		return sig, timeout, false
+	case <-time.After(timeout):
+	}
+
+	syscall.Kill(-pid, syscall.SIGKILL)
+	<-exited
+	return sig, timeout, true
+}
@@ -0,0 +1,208 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+	"github.com/Arihantawasthi/sage.git/internal/utils"
+	"github.com/shirou/gopsutil/process"
+)
+
+var errUnknownExit = errors.New("exited while adopted, status unknown")
+
+// savedProcess is what the daemon remembers about a running service across
+// restarts. CreateTime is the kernel's start time for the PID and guards
+// against adopting an unrelated process that reused it.
+type savedProcess struct {
+	Pid        int       `json:"pid"`
+	CreateTime int64     `json:"createTime"`
+	StartedAt  time.Time `json:"startedAt"`
+	Restarts   int       `json:"restarts"`
+	// Holder is the pipe holder of the service, see HoldPipes, 0 if it
+	// couldn't be started.
+	Holder           int   `json:"holder,omitempty"`
+	HolderCreateTime int64 `json:"holderCreateTime,omitempty"`
+}
+
+type savedState struct {
+	Services map[string]savedProcess `json:"services"`
+}
+
+// serviceRun is a single incarnation of a service: either a child spawned by
+// this daemon or a process re-adopted from a previous one.
+type serviceRun struct {
+	pid        int
+	createTime int64
+	wait       func() error
+	// holder is the pipe holder of a spawned run, 0 if it has none.
+	holder           int
+	holderCreateTime int64
+}
+
+func (ps *ProcessStore) statePath() string {
+	return filepath.Join(ps.paths.StateDir, "state.json")
+}
+
+func processCreateTime(pid int) (int64, error) {
+	proc, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return 0, err
+	}
+	return proc.CreateTime()
+}
+
+// adoptedRun watches a process that isn't a child of this daemon. Its exit
+// status can't be collected, so the run only notices that it is gone.
+func adoptedRun(pid int, createTime int64) *serviceRun {
+	return &serviceRun{
+		pid:        pid,
+		createTime: createTime,
+		wait: func() error {
+			for {
+				time.Sleep(time.Second)
+				if ct, err := processCreateTime(pid); err != nil || ct != createTime {
+					return errUnknownExit
+				}
+			}
+		},
+	}
+}
+
+// saveState writes every running service to the state file so that a
+// restarted daemon can pick them up again.
+func (ps *ProcessStore) saveState() {
+	state := savedState{Services: make(map[string]savedProcess)}
+	ps.mu.RLock()
+	for name, rp := range ps.store {
+		if rp.Status != models.StatusOnline || rp.Pid == 0 {
+			continue
+		}
+		state.Services[name] = savedProcess{
+			Pid:        rp.Pid,
+			CreateTime: rp.CreateTime,
+			StartedAt:  rp.StartedAt,
+			Restarts:   rp.Restarts,
+
+			Holder:           rp.Holder,
+			HolderCreateTime: rp.HolderCreateTime,
+		}
+	}
+	ps.mu.RUnlock()
+
+	ps.stateMu.Lock()
+	defer ps.stateMu.Unlock()
+	path := ps.statePath()
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		ps.log.Error("failed to encode state", "err", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		ps.log.Error("failed to create the state directory", "path", filepath.Dir(path), "err", err)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		ps.log.Error("failed to write the state file", "path", path, "err", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ps.log.Error("failed to write the state file", "path", path, "err", err)
+	}
+}
+
+// RestoreState re-adopts the services recorded in the state file that are
+// still running as the very same process, and returns their names.
+func (ps *ProcessStore) RestoreState() ([]string, error) {
+	path := ps.statePath()
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file '%s': %w", path, err)
+	}
+	var state savedState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("error decoding state file '%s': %w", path, err)
+	}
+
+	cfg := ps.Config()
+	var adopted []string
+	for name, saved := range state.Services {
+		service, exists := cfg.ServiceMap[name]
+		if !exists {
+			ps.serviceLog(name).Info("not adopting, the service is no longer configured", "pid", saved.Pid)
+			continue
+		}
+		if ct, err := processCreateTime(saved.Pid); err != nil || ct != saved.CreateTime {
+			continue
+		}
+
+		rp := &models.Process{
+			Pid:        saved.Pid,
+			Name:       name,
+			PName:      name,
+			Cmd:        service.Command,
+			UpTime:     "0s",
+			Status:     models.StatusOnline,
+			Health:     initialHealth(service),
+			Restarts:   saved.Restarts,
+			CreateTime: saved.CreateTime,
+			StartedAt:  saved.StartedAt,
+			Service:    service,
+			StopChan:   make(chan struct{}),
+			ExitChan:   make(chan struct{}),
+
+			Holder:           saved.Holder,
+			HolderCreateTime: saved.HolderCreateTime,
+		}
+		ps.mu.Lock()
+		ps.store[name] = rp
+		ps.mu.Unlock()
+
+		ps.reattachLogs(name, service, saved)
+		if service.Limits.NeedsCgroup() {
+			ps.cgroups.setup()
+		}
+		go ps.supervise(name, service, rp, adoptedRun(saved.Pid, saved.CreateTime))
+		adopted = append(adopted, name)
+	}
+
+	ps.saveState()
+	return adopted, nil
+}
+
+// reattachLogs reopens the pipe read ends the holder of an adopted service
+// keeps open and resumes copying its output into the service log.
+func (ps *ProcessStore) reattachLogs(serviceName string, service models.Service, saved savedProcess) {
+	log := ps.serviceLog(serviceName).With("pid", saved.Pid)
+	holder := saved.Holder
+	if holder == 0 {
+		log.Warn("the service has no pipe holder, output is not reattached")
+		return
+	}
+	if ct, err := processCreateTime(holder); err != nil || ct != saved.HolderCreateTime {
+		log.Warn("the pipe holder is gone, output is not reattached", "holder", holder)
+		return
+	}
+	logWriter, err := ps.logWriter(serviceName, service)
+	if err != nil {
+		log.Error("cannot reopen the log", "err", err)
+		return
+	}
+	streams := map[string]int{models.StreamStdout: stdoutReaderFd, models.StreamStderr: stderrReaderFd}
+	for stream, fd := range streams {
+		pipe, err := os.Open(fmt.Sprintf("/proc/%d/fd/%d", holder, fd))
+		if err != nil {
+			log.Error("cannot reattach the output", "stream", stream, "err", err)
+			continue
+		}
+		go ps.streamLogs(pipe, utils.LogSource{Service: serviceName, Stream: stream, Pid: saved.Pid}, logFormat(service), logWriter)
+	}
+}
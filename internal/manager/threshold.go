@@ -0,0 +1,125 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+const (
+	monitorInterval = 5 * time.Second
+	// maxEvents is how many events the daemon keeps, oldest dropped first.
+	maxEvents = 500
+)
+
+// sample is what thresholds are checked against. cpu is measured between
+// two samples rather than over the processes' lifetime.
+type sample struct {
+	cpu    float64
+	memory float64
+	rss    models.ByteSize
+}
+
+// breach tracks for how long a threshold has been exceeded.
+type breach struct {
+	samples int
+	since   time.Time
+}
+
+// checkThresholds updates the breaches of the service's thresholds with a new
+// sample and acts on those that fired. It returns true when it restarted the
+// service, which ends the run being monitored.
+func (ps *ProcessStore) checkThresholds(serviceName string, service models.Service, rp *models.Process, pid int, s sample, breaches []breach, done chan struct{}) bool {
+	now := time.Now()
+	log := ps.serviceLog(serviceName).With("pid", pid)
+	for i, t := range service.Thresholds {
+		exceeded, what := thresholdExceeded(t, s)
+		if !exceeded {
+			breaches[i] = breach{}
+			continue
+		}
+		b := &breaches[i]
+		b.samples++
+		if b.since.IsZero() {
+			b.since = now
+		}
+
+		var fired bool
+		switch {
+		case t.Samples > 0:
+			fired = b.samples >= t.Samples
+			what = fmt.Sprintf("%s for %d samples", what, b.samples)
+		case t.For.Duration > 0:
+			// The first sample counts as a full interval of breach.
+			lasted := now.Sub(b.since) + monitorInterval
+			fired = lasted >= t.For.Duration
+			what = fmt.Sprintf("%s for %s", what, t.For.Duration)
+		default:
+			fired = true
+		}
+		if !fired {
+			continue
+		}
+		*b = breach{}
+
+		switch t.Action {
+		case models.ActionEvent:
+			log.Info("threshold fired", "breach", what)
+			ps.recordEvent(serviceName, models.EventThreshold, what)
+		case models.ActionRestart:
+			log.Warn("threshold fired, restarting", "breach", what)
+			ps.mu.Lock()
+			if rp.Pid != pid {
+				ps.mu.Unlock()
+				return true
+			}
+			rp.RestartReason = what
+			ps.mu.Unlock()
+			ps.recordEvent(serviceName, models.EventRestart, what)
+			terminate(log, pid, service, done)
+			return true
+		default:
+			log.Warn("threshold fired", "breach", what)
+		}
+	}
+	return false
+}
+
+// thresholdExceeded compares the sample with the one metric a threshold is
+// set for and describes the breach.
+func thresholdExceeded(t models.Threshold, s sample) (bool, string) {
+	switch {
+	case t.CPU > 0:
+		return s.cpu > t.CPU, fmt.Sprintf("cpu %.1f%% above %.1f%%", s.cpu, t.CPU)
+	case t.Memory > 0:
+		return s.memory > t.Memory, fmt.Sprintf("memory %.1f%% above %.1f%%", s.memory, t.Memory)
+	case t.RSS > 0:
+		return s.rss > t.RSS, fmt.Sprintf("rss %s above %s", s.rss, t.RSS)
+	}
+	return false, ""
+}
+
+// recordEvent adds an event to the daemon's log of recent events.
+func (ps *ProcessStore) recordEvent(serviceName, eventType, msg string) {
+	ps.eventsMu.Lock()
+	defer ps.eventsMu.Unlock()
+	ps.events = append(ps.events, models.Event{Time: time.Now(), Service: serviceName, Type: eventType, Msg: msg})
+	if len(ps.events) > maxEvents {
+		ps.events = ps.events[len(ps.events)-maxEvents:]
+	}
+}
+
+// Events returns the recorded events of a service, or of every service when
+// serviceName is empty, oldest first.
+func (ps *ProcessStore) Events(serviceName string) []models.Event {
+	ps.eventsMu.Lock()
+	defer ps.eventsMu.Unlock()
+	var events []models.Event
+	for _, e := range ps.events {
+		if serviceName == "" || e.Service == serviceName {
+			events = append(events, e)
+		}
+	}
+	return events
+}
@@ -1,27 +1,387 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 type Service struct {
-	Name       string            `json:"name"`
-	Command    string            `json:"command"`
-	Args       []string          `json:"args"`
-	WorkingDir string            `json:"workingDir"`
-	Env        map[string]string `json:"env,omitempty"`
+	Name         string            `json:"name" yaml:"name" toml:"name"`
+	Command      string            `json:"command" yaml:"command" toml:"command"`
+	Args         []string          `json:"args" yaml:"args" toml:"args"`
+	WorkingDir   string            `json:"workingDir" yaml:"workingDir" toml:"workingDir"`
+	User         string            `json:"user,omitempty" yaml:"user,omitempty" toml:"user,omitempty"`
+	Group        string            `json:"group,omitempty" yaml:"group,omitempty" toml:"group,omitempty"`
+	Env          map[string]string `json:"env,omitempty" yaml:"env,omitempty" toml:"env,omitempty"`
+	EnvFile      []string          `json:"envFile,omitempty" yaml:"envFile,omitempty" toml:"envFile,omitempty"`
+	Restart      RestartPolicy     `json:"restart" yaml:"restart" toml:"restart"`
+	StopSignal   string            `json:"stopSignal,omitempty" yaml:"stopSignal,omitempty" toml:"stopSignal,omitempty"`
+	StopTimeout  Duration          `json:"stopTimeout" yaml:"stopTimeout" toml:"stopTimeout"`
+	Readiness    *Probe            `json:"readiness,omitempty" yaml:"readiness,omitempty" toml:"readiness,omitempty"`
+	StartTimeout Duration          `json:"startTimeout" yaml:"startTimeout" toml:"startTimeout"`
+	Liveness     *LivenessProbe    `json:"liveness,omitempty" yaml:"liveness,omitempty" toml:"liveness,omitempty"`
+	DependsOn    []string          `json:"dependsOn,omitempty" yaml:"dependsOn,omitempty" toml:"dependsOn,omitempty"`
+	Autostart    bool              `json:"autostart" yaml:"autostart" toml:"autostart"`
+	Schedule     string            `json:"schedule,omitempty" yaml:"schedule,omitempty" toml:"schedule,omitempty"`
+	Overlap      string            `json:"overlap,omitempty" yaml:"overlap,omitempty" toml:"overlap,omitempty"`
+	Limits       *Limits           `json:"limits,omitempty" yaml:"limits,omitempty" toml:"limits,omitempty"`
+	Thresholds   []Threshold       `json:"thresholds,omitempty" yaml:"thresholds,omitempty" toml:"thresholds,omitempty"`
+	Logs         *LogConfig        `json:"logs,omitempty" yaml:"logs,omitempty" toml:"logs,omitempty"`
+}
+
+// LogConfig sets the Format of a service's log lines and rotates its log
+// file once it reaches MaxSize and, with Daily, at the first line of every
+// day. MaxBackups rotated files are kept, none of them older than MaxAge, and
+// Compress gzips them. Zero means no limit.
+type LogConfig struct {
+	Format     string   `json:"format,omitempty" yaml:"format,omitempty" toml:"format,omitempty"`
+	MaxSize    ByteSize `json:"maxSize,omitempty" yaml:"maxSize,omitempty" toml:"maxSize,omitempty"`
+	Daily      bool     `json:"daily,omitempty" yaml:"daily,omitempty" toml:"daily,omitempty"`
+	MaxBackups int      `json:"maxBackups,omitempty" yaml:"maxBackups,omitempty" toml:"maxBackups,omitempty"`
+	MaxAge     Duration `json:"maxAge" yaml:"maxAge" toml:"maxAge"`
+	Compress   bool     `json:"compress,omitempty" yaml:"compress,omitempty" toml:"compress,omitempty"`
+}
+
+// LogFormatText lines read "<time> [<stream>][<service>] <line>";
+// LogFormatJSON lines are objects with ts, service, stream, pid and msg
+// keys, msg being the object itself for lines that already are one.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Overlap policies decide what happens when a scheduled service is due while
+// its previous run is still going: the new run is skipped, queued to start
+// once the previous one exits, or replaces it.
+const (
+	OverlapSkip    = "skip"
+	OverlapQueue   = "queue"
+	OverlapReplace = "replace"
+)
+
+// Threshold acts when a service's usage, summed over all of its processes,
+// stays above one of CPU (percent of a core), Memory (percent of total
+// memory) or RSS for Samples consecutive samples or for the duration For,
+// whichever is set. Without either, a single sample is enough.
+type Threshold struct {
+	CPU     float64  `json:"cpu,omitempty" yaml:"cpu,omitempty" toml:"cpu,omitempty"`
+	Memory  float64  `json:"memory,omitempty" yaml:"memory,omitempty" toml:"memory,omitempty"`
+	RSS     ByteSize `json:"rss,omitempty" yaml:"rss,omitempty" toml:"rss,omitempty"`
+	Samples int      `json:"samples,omitempty" yaml:"samples,omitempty" toml:"samples,omitempty"`
+	For     Duration `json:"for" yaml:"for" toml:"for"`
+	Action  string   `json:"action" yaml:"action" toml:"action"`
+}
+
+const (
+	ActionWarn    = "warn"
+	ActionEvent   = "event"
+	ActionRestart = "restart"
+)
+
+// Event is something noteworthy that happened to a service, kept by the
+// daemon for `sagectl events`.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Type    string    `json:"type"`
+	Msg     string    `json:"msg"`
+}
+
+const (
+	EventThreshold = "threshold"
+	EventRestart   = "restart"
+	EventSchedule  = "schedule"
+)
+
+// Limits caps the resources of a service. NoFile, NProc and Core are
+// rlimits; Memory, CPU and Pids are enforced through a cgroup v2 sub-tree
+// when one is available. Zero means no limit, except for Core, which is only
+// unlimited when unset.
+type Limits struct {
+	NoFile uint64    `json:"nofile,omitempty" yaml:"nofile,omitempty" toml:"nofile,omitempty"`
+	NProc  uint64    `json:"nproc,omitempty" yaml:"nproc,omitempty" toml:"nproc,omitempty"`
+	Core   *ByteSize `json:"core,omitempty" yaml:"core,omitempty" toml:"core,omitempty"`
+	Memory ByteSize  `json:"memory,omitempty" yaml:"memory,omitempty" toml:"memory,omitempty"`
+	// CPU is a quota in CPUs, e.g. 1.5 for one and a half cores.
+	CPU  float64 `json:"cpu,omitempty" yaml:"cpu,omitempty" toml:"cpu,omitempty"`
+	Pids int64   `json:"pids,omitempty" yaml:"pids,omitempty" toml:"pids,omitempty"`
+}
+
+// NeedsCgroup reports whether any of the limits is enforced by a cgroup.
+func (l *Limits) NeedsCgroup() bool {
+	return l != nil && (l.Memory > 0 || l.CPU > 0 || l.Pids > 0)
+}
+
+// Probe checks whether a service is up. Address is used by tcp probes, URL by
+// http probes, Command by exec probes and Pattern (a regexp matched against
+// the service's log lines) by log probes. Timeout bounds a single attempt and
+// Interval is the pause between attempts.
+type Probe struct {
+	Type     string   `json:"type" yaml:"type" toml:"type"`
+	Address  string   `json:"address,omitempty" yaml:"address,omitempty" toml:"address,omitempty"`
+	URL      string   `json:"url,omitempty" yaml:"url,omitempty" toml:"url,omitempty"`
+	Command  []string `json:"command,omitempty" yaml:"command,omitempty" toml:"command,omitempty"`
+	Pattern  string   `json:"pattern,omitempty" yaml:"pattern,omitempty" toml:"pattern,omitempty"`
+	Interval Duration `json:"interval" yaml:"interval" toml:"interval"`
+	Timeout  Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+}
+
+// LivenessProbe runs a Probe periodically for as long as the service is up.
+// After FailureThreshold consecutive failures the service is marked unhealthy
+// and, when Restart is set, restarted.
+type LivenessProbe struct {
+	Probe            `yaml:",inline"`
+	FailureThreshold int  `json:"failureThreshold" yaml:"failureThreshold" toml:"failureThreshold"`
+	Restart          bool `json:"restart" yaml:"restart" toml:"restart"`
+}
+
+const (
+	ProbeTCP  = "tcp"
+	ProbeHTTP = "http"
+	ProbeExec = "exec"
+	ProbeLog  = "log"
+)
+
+// RestartPolicy decides whether the daemon respawns a service after it exits.
+// Delays grow exponentially from InitialDelay up to MaxDelay, with Jitter as a
+// fraction (0-1) of the delay added at random. MaxRetries restarts are allowed
+// within RetryWindow; zero means no limit and no window respectively.
+type RestartPolicy struct {
+	Policy       string   `json:"policy" yaml:"policy" toml:"policy"`
+	InitialDelay Duration `json:"initialDelay" yaml:"initialDelay" toml:"initialDelay"`
+	MaxDelay     Duration `json:"maxDelay" yaml:"maxDelay" toml:"maxDelay"`
+	Jitter       float64  `json:"jitter" yaml:"jitter" toml:"jitter"`
+	MaxRetries   int      `json:"maxRetries" yaml:"maxRetries" toml:"maxRetries"`
+	RetryWindow  Duration `json:"retryWindow" yaml:"retryWindow" toml:"retryWindow"`
+}
+
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// Duration is a time.Duration written as a string ("500ms", "2m") in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// UnmarshalYAML is only there to put the line number into the error, which
+// yaml.v3 leaves out for errors returned by UnmarshalText.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	if err := d.UnmarshalText([]byte(node.Value)); err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// ByteSize is a number of bytes, written in config files either as a plain
+// number or with a K, M, G or T suffix (powers of 1024), e.g. "512M".
+type ByteSize uint64
+
+var byteUnits = []struct {
+	suffix string
+	size   ByteSize
+}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.ToUpper(strings.TrimSpace(string(text)))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := ByteSize(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, multiplier = strings.TrimSuffix(s, u.suffix), u.size
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid size %q", text)
+	}
+	*b = ByteSize(v * float64(multiplier))
+	return nil
+}
+
+// UnmarshalJSON takes plain numbers as well as strings.
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+	return b.UnmarshalText([]byte(s))
+}
+
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	if err := b.UnmarshalText([]byte(node.Value)); err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	return nil
+}
+
+// MarshalText keeps the exact size and only uses a suffix when it divides
+// evenly.
+func (b ByteSize) MarshalText() ([]byte, error) {
+	for _, u := range byteUnits {
+		if b >= u.size && b%u.size == 0 {
+			return []byte(fmt.Sprintf("%d%s", b/u.size, u.suffix)), nil
+		}
+	}
+	return []byte(strconv.FormatUint(uint64(b), 10)), nil
+}
+
+// String rounds to one decimal in the largest unit that fits.
+func (b ByteSize) String() string {
+	for _, u := range byteUnits {
+		if b >= u.size {
+			if b%u.size == 0 {
+				return fmt.Sprintf("%d%s", b/u.size, u.suffix)
+			}
+			return fmt.Sprintf("%.1f%s", float64(b)/float64(u.size), u.suffix)
+		}
+	}
+	return strconv.FormatUint(uint64(b), 10)
 }
 
 type Services struct {
-	Services []Service `json:"services"`
+	Services    []Service `json:"services" yaml:"services" toml:"services"`
+	MaxParallel int       `json:"maxParallel" yaml:"maxParallel" toml:"maxParallel"`
+	Shutdown    string    `json:"shutdown" yaml:"shutdown" toml:"shutdown"`
+	// Include lists globs of further config files, relative to the main one.
+	Include []string `json:"include,omitempty" yaml:"include,omitempty" toml:"include,omitempty"`
+	// Defaults are inherited by every service for the fields it leaves unset.
+	Defaults  Service   `json:"defaults" yaml:"defaults" toml:"defaults"`
+	DaemonLog DaemonLog `json:"daemonLog" yaml:"daemonLog" toml:"daemonLog"`
+}
+
+// DaemonLog configures the daemon's own log: the lowest Level written
+// (debug, info, warn or error), its Format (text or json) and its rotation
+// into numbered files once it reaches MaxSize, keeping MaxBackups of them.
+// Unset, MaxSize and MaxBackups take their defaults; zero means no limit, as
+// it does for LogConfig.
+type DaemonLog struct {
+	Level      string    `json:"level,omitempty" yaml:"level,omitempty" toml:"level,omitempty"`
+	Format     string    `json:"format,omitempty" yaml:"format,omitempty" toml:"format,omitempty"`
+	MaxSize    *ByteSize `json:"maxSize,omitempty" yaml:"maxSize,omitempty" toml:"maxSize,omitempty"`
+	MaxBackups *int      `json:"maxBackups,omitempty" yaml:"maxBackups,omitempty" toml:"maxBackups,omitempty"`
 }
 
 type Config struct {
 	ServiceMap map[string]Service `json:"serviceMap"`
+	// MaxParallel caps how many services `start all`/`stop all` act on at once.
+	MaxParallel int `json:"maxParallel"`
+	// Shutdown decides what happens to running services when the daemon
+	// exits: ShutdownLeave (default) or ShutdownStop.
+	Shutdown string `json:"shutdown"`
+	// Sources maps every service to the file it was defined in.
+	Sources   map[string]string `json:"sources"`
+	DaemonLog DaemonLog         `json:"daemonLog"`
+}
+
+// ServiceStatus is what `sagectl status` shows about a service: its state,
+// the limits it was started with and the usage of its cgroup, if it has one.
+type ServiceStatus struct {
+	Name   string       `json:"name"`
+	Status string       `json:"status"`
+	Pid    int          `json:"pid"`
+	UpTime string       `json:"upTime"`
+	Limits *Limits      `json:"limits,omitempty"`
+	Cgroup string       `json:"cgroup,omitempty"`
+	Usage  *CgroupUsage `json:"usage,omitempty"`
+}
+
+// CgroupUsage is read from a service's cgroup. The max values are the raw
+// contents of the cgroup files, "max" meaning unlimited.
+type CgroupUsage struct {
+	MemoryCurrent ByteSize `json:"memoryCurrent"`
+	MemoryPeak    ByteSize `json:"memoryPeak"`
+	MemoryMax     string   `json:"memoryMax"`
+	OOMKills      uint64   `json:"oomKills"`
+	CPUTime       Duration `json:"cpuTime"`
+	CPUMax        string   `json:"cpuMax"`
+	PidsCurrent   uint64   `json:"pidsCurrent"`
+	PidsMax       string   `json:"pidsMax"`
 }
 
+// ServiceInfo is what `sagectl describe` shows about a service.
+type ServiceInfo struct {
+	Service Service `json:"service"`
+	Source  string  `json:"source"`
+	Status  string  `json:"status"`
+	Pid     int     `json:"pid"`
+	// Outdated is set when the service runs with an older definition.
+	Outdated bool `json:"outdated"`
+}
+
+const (
+	ShutdownLeave = "leave"
+	ShutdownStop  = "stop"
+)
+
 type Response[T any] struct {
 	RequestStatus uint8  `json:"requestStatus"`
 	Msg           string `json:"msg"`
 	Data          T      `json:"data"`
 }
 
+// LogsRequest asks for the last Lines lines of a service's log (all of them
+// when negative) written since Since, from one Stream or both when it is
+// empty. With Follow, every matching line written afterwards is sent too.
+type LogsRequest struct {
+	Service string    `json:"service"`
+	Lines   int       `json:"lines"`
+	Follow  bool      `json:"follow"`
+	Stream  string    `json:"stream,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// ServiceResult is the outcome of a bulk operation for a single service.
+type ServiceResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Msg    string `json:"msg"`
+}
+
+const (
+	ResultOK      = "ok"
+	ResultFailed  = "failed"
+	ResultSkipped = "skipped"
+)
+
+// ConfigDiff lists the services a config reload added, removed or changed.
+type ConfigDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+type ReloadResult struct {
+	ConfigDiff
+	Results []ServiceResult `json:"results"`
+}
+
 type PListData struct {
 	Pid        int     `json:"pid"`
 	PName      string  `json:"pname"`
@@ -31,6 +391,24 @@ type PListData struct {
 	UpTime     string  `json:"uptime"`
 	CPUPercent float64 `json:"cpuPercent"`
 	MemPrecent float32 `json:"memPercent"`
+	Restarts   int     `json:"restarts"`
+	LastExit   string  `json:"lastExit"`
+	Health     string  `json:"health"`
+	// Job is only set for scheduled services.
+	Job *JobStatus `json:"job,omitempty"`
+}
+
+// JobStatus is the schedule of a scheduled service along with its last and
+// next run. ExitCode is nil while the last run is going or when its exit code
+// is unknown; a run killed by a signal gets 128 plus the signal number.
+type JobStatus struct {
+	Schedule string    `json:"schedule"`
+	Overlap  string    `json:"overlap"`
+	LastRun  time.Time `json:"lastRun"`
+	Duration Duration  `json:"duration"`
+	ExitCode *int      `json:"exitCode"`
+	NextRun  time.Time `json:"nextRun"`
+	Queued   bool      `json:"queued"`
 }
 
 type Process struct {
@@ -41,7 +419,37 @@ type Process struct {
 	UpTime     string
 	CPUPercent float64
 	MemPrecent float32
-	StopChan   chan struct{}
+	Status     string
+	Restarts   int
+	LastExit   string
+	Health     string
+	CreateTime int64
+	StartedAt  time.Time
+	// Holder is the process keeping the output pipes of the service open
+	// while the daemon is down, 0 if there is none.
+	Holder           int
+	HolderCreateTime int64
+	// ExitedAt and ExitCode describe the last exit, see JobStatus.
+	ExitedAt time.Time
+	ExitCode *int
+	// Service is the definition the process was started with, which differs
+	// from the configured one after a reload until the service is restarted.
+	Service Service
+	// RestartReason, when set, makes the supervisor respawn the service on
+	// its next exit regardless of the restart policy, and says why.
+	RestartReason string
+	StopChan      chan struct{}
+	ExitChan      chan struct{}
 }
 
-const LogFilePath = ".sage/saged/saged.log"
+const (
+	StatusOnline     = "online"
+	StatusOffline    = "offline"
+	StatusRestarting = "restarting"
+	StatusStopping   = "stopping"
+	StatusErrored    = "errored"
+
+	HealthUnknown   = "unknown"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
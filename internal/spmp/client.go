@@ -1,17 +1,26 @@
 package spmp
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
+
+	"github.com/Arihantawasthi/sage.git/internal/config"
 )
 
 type SPMPClient struct {
 	socketPath string
 }
 
-func NewSPMPClient() *SPMPClient {
+// NewSPMPClient connects to the daemon at socketPath, or at the socket a
+// daemon with the default paths listens on when it is empty.
+func NewSPMPClient(socketPath string) *SPMPClient {
+	if socketPath == "" {
+		socketPath = config.FindSocket()
+	}
 	return &SPMPClient{
-		socketPath: "/tmp/sage.sock",
+		socketPath: socketPath,
 	}
 }
 
@@ -37,3 +46,33 @@ func (c *SPMPClient) SendAndReceivePacket(pkt *Packet) (*Packet, error) {
     }
     return decodedPkt, nil
 }
+
+// Stream sends pkt and passes every packet that comes back to handle until
+// the daemon closes the connection.
+func (c *SPMPClient) Stream(pkt *Packet, handle func(*Packet) error) error {
+	conn, err := net.Dial("unix", c.socketPath)
+	if err != nil {
+		return fmt.Errorf("error connecting to unix socket: %w", err)
+	}
+	defer conn.Close()
+	packetBytes, err := pkt.Encode()
+	if err != nil {
+		return fmt.Errorf("error while ecoding packet: %s", err)
+	}
+	if _, err := conn.Write(packetBytes); err != nil {
+		return fmt.Errorf("error writing to the connection: %v", err)
+	}
+
+	for {
+		received, err := DecodePacket(conn)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error while decoding the received bytes: %s", err)
+		}
+		if err := handle(received); err != nil {
+			return err
+		}
+	}
+}
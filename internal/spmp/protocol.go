@@ -15,14 +15,44 @@ const (
 	JSONEncoding string = "JS"
 	TEXTEncoding string = "TX"
 
-	TypeList   byte = 0x01
-	TypeStatus byte = 0x02
-	TypeStart  byte = 0x03
-	TypeStop   byte = 0x04
+	TypeList     byte = 0x01
+	TypeStatus   byte = 0x02
+	TypeStart    byte = 0x03
+	TypeStop     byte = 0x04
+	TypeReload   byte = 0x05
+	TypeDescribe byte = 0x06
+	TypeEvents   byte = 0x07
+	TypeLogs     byte = 0x08
 
 	HeaderSize uint32 = 10
+
+	// AllServices as a start/stop payload applies the command to every service.
+	AllServices string = "all"
 )
 
+// ReloadRestartChanged as a reload payload also restarts changed services.
+const ReloadRestartChanged = "restart-changed"
+
+// typeNames name the message types in the daemon log.
+var typeNames = map[byte]string{
+	TypeList:     "list",
+	TypeStatus:   "status",
+	TypeStart:    "start",
+	TypeStop:     "stop",
+	TypeReload:   "reload",
+	TypeDescribe: "describe",
+	TypeEvents:   "events",
+	TypeLogs:     "logs",
+}
+
+func validType(t byte) bool {
+	switch t {
+	case TypeList, TypeStatus, TypeStart, TypeStop, TypeReload, TypeDescribe, TypeEvents, TypeLogs:
+		return true
+	}
+	return false
+}
+
 type Packet struct {
 	MagicBytes  [2]byte
 	Version     byte
@@ -123,7 +153,7 @@ func DecodePacket(conn net.Conn) (*Packet, error) {
 	if err := binary.Read(reader, binary.BigEndian, &pkt.Type); err != nil {
 		return nil, fmt.Errorf("failed to read type bytes: %w", err)
 	}
-	if pkt.Type != TypeList && pkt.Type != TypeStatus && pkt.Type != TypeStart && pkt.Type != TypeStop {
+	if !validType(pkt.Type) {
 		return nil, fmt.Errorf("invalid type: '%b'", pkt.Version)
 	}
 
@@ -1,132 +1,246 @@
 package spmp
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 
+	"github.com/Arihantawasthi/sage.git/internal/config"
 	"github.com/Arihantawasthi/sage.git/internal/logger"
 	"github.com/Arihantawasthi/sage.git/internal/manager"
 	"github.com/Arihantawasthi/sage.git/internal/models"
 )
 
+// maxLogFrame is roughly the largest payload a log frame carries; a single
+// longer line still goes out in one frame.
+const maxLogFrame = 64 * 1024
+
+// Handlers get a logger carrying the connection and the request type.
 type SPMPServer struct {
-	cfg    models.Config
-	logger *logger.SlogLogger
-	router map[byte]func(*Packet) ([]byte, string, error)
-	ps     *manager.ProcessStore
+	logger   *logger.SlogLogger
+	router   map[byte]func(logger.Logger, *Packet) ([]byte, string, error)
+	// streams handle the requests that are answered with a series of
+	// frames written straight to the connection.
+	streams  map[byte]func(logger.Logger, net.Conn, *Packet) error
+	ps       *manager.ProcessStore
+	mu       sync.Mutex
+	listener net.Listener
+	socket   string
+	// conns numbers the connections for the log.
+	conns atomic.Uint64
 }
 
-func NewSPMPServer(cfg models.Config, logger *logger.SlogLogger, processStore *manager.ProcessStore) *SPMPServer {
+func NewSPMPServer(log *logger.SlogLogger, processStore *manager.ProcessStore, socketPath string) *SPMPServer {
 	s := &SPMPServer{
-		cfg:    cfg,
-		logger: logger,
-		router: make(map[byte]func(*Packet) ([]byte, string, error)),
-		ps:     processStore,
+		logger:  log,
+		router:  make(map[byte]func(logger.Logger, *Packet) ([]byte, string, error)),
+		streams: make(map[byte]func(logger.Logger, net.Conn, *Packet) error),
+		ps:      processStore,
+		socket:  socketPath,
 	}
 	s.router[TypeStart] = s.handleStart
 	s.router[TypeList] = s.handleList
 	s.router[TypeStop] = s.handleStop
+	s.router[TypeReload] = s.handleReload
+	s.router[TypeDescribe] = s.handleDescribe
+	s.router[TypeStatus] = s.handleStatus
+	s.router[TypeEvents] = s.handleEvents
+	s.streams[TypeLogs] = s.handleLogs
 
 	return s
 }
 
+// Start serves SPMP requests until Shutdown is called.
 func (s *SPMPServer) Start() error {
-    socketPath := "/tmp/sage.sock"
-    if _, err := os.Stat(socketPath); err == nil {
-        err := os.Remove(socketPath)
+    if _, err := os.Stat(s.socket); err == nil {
+        err := os.Remove(s.socket)
         if err != nil {
-            s.logger.Error("Failed to remove existing socket", "START", "os.Remove", "", "", "")
+            s.logger.Error("Failed to remove existing socket", "socket", s.socket, "err", err)
             return err
         }
-        s.logger.Info("Removed existing socket file", "START", "os.Remove", "", "", "")
+        s.logger.Info("Removed existing socket file", "socket", s.socket)
     }
 
-	listener, err := net.Listen("unix", socketPath)
+	listener, err := net.Listen("unix", s.socket)
 	if err != nil {
-		s.logger.Error("Failed to start SPMP server", "START", "net.Listen", "", "", "")
+		s.logger.Error("Failed to start SPMP server", "socket", s.socket, "err", err)
 		return err
 	}
-	defer listener.Close()
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
 
-	s.logger.Info("SPMP Server Started", "START", "net.Listen", "", "", "")
+	s.logger.Info("SPMP Server Started", "socket", s.socket)
 
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
 		if err != nil {
-			s.logger.Error("Failed to accept connection", "START", "Accept", "", "", "")
+			s.logger.Error("Failed to accept connection", "err", err)
 			continue
 		}
 		go s.handleConnection(conn)
 	}
 }
 
+// Shutdown stops accepting connections and removes the socket file.
+func (s *SPMPServer) Shutdown() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	if err := os.Remove(s.socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.logger.Info("SPMP Server Stopped", "socket", s.socket)
+	return nil
+}
+
 func (s *SPMPServer) handleConnection(conn net.Conn) {
 	defer conn.Close()
-	remoteAddr := conn.RemoteAddr().String()
-	s.logger.Info("Accepted new connection", "func: handleConnection", "Accept", remoteAddr, "", "")
+	log := s.logger.With("conn", s.conns.Add(1))
+	log.Debug("Accepted new connection")
 
 	packet, err := DecodePacket(conn)
 	if err != nil {
-		s.logger.Info("Error in decoding the packet", "func: handleConnection", "DecodePacket", remoteAddr, "", err)
+		log.Warn("Error in decoding the packet", "err", err)
+		return
+	}
+	log = log.With("type", typeNames[packet.Type])
+	log.Debug("Received request", "payload", len(packet.Payload))
+
+	if stream, exists := s.streams[packet.Type]; exists {
+		if err := stream(log, conn, packet); err != nil {
+			log.Error("Stream failed", "err", err)
+		}
 		return
 	}
 
 	handler, exists := s.router[packet.Type]
 	if !exists {
-		err = fmt.Errorf("unknown command type: %d", packet.Type)
-		s.logger.Error("No handler found for command type", "func: handleConnection", "router lookup", remoteAddr, "", err)
+		log.Error("No handler found for command type", "err", fmt.Errorf("unknown command type: %d", packet.Type))
 		return
 	}
 
-	responsePayload, encoding, err := handler(packet)
+	responsePayload, encoding, err := handler(log, packet)
 	if err != nil {
-		s.logger.Error("Handler failed", "func: handleConnection", "handler", remoteAddr, "", err)
+		log.Error("Handler failed", "err", err)
 		return
 	}
 
-	responsePkt, err := NewPacket(V1, encoding, packet.Type, responsePayload)
-	if err != nil {
-		s.logger.Error("failed to build response packet", "func: handleConnection", "NewPacket", remoteAddr, "", err)
+	if err := writePacket(conn, encoding, packet.Type, responsePayload); err != nil {
+		log.Error("Failed to write the response", "err", err)
 		return
 	}
+}
 
-	encoded, err := responsePkt.Encode()
+func writePacket(conn net.Conn, encoding string, msgType byte, payload []byte) error {
+	pkt, err := NewPacket(V1, encoding, msgType, payload)
 	if err != nil {
-		s.logger.Error("failed to encode packet", "func: handleConnection", "Encode", remoteAddr, "", err)
-		return
+		return fmt.Errorf("failed to build response packet: %w", err)
+	}
+	encoded, err := pkt.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode packet: %w", err)
+	}
+	_, err = conn.Write(encoded)
+	return err
+}
+
+// handleLogs writes the requested log lines as TEXT frames, then keeps
+// writing new ones when following until the client disconnects. Errors are
+// sent as a JSON response with RequestStatus 0.
+func (s *SPMPServer) handleLogs(log logger.Logger, conn net.Conn, pkt *Packet) error {
+	var req models.LogsRequest
+	if err := json.Unmarshal(pkt.Payload, &req); err != nil {
+		return writeLogsError(conn, fmt.Errorf("invalid logs request: %v", err))
 	}
 
-	if _, err := conn.Write(encoded); err != nil {
-		s.logger.Error("Failed to write the response", "func: handleConnection", "Write", remoteAddr, "", err)
-		return
+	log.Debug("Streaming logs", "service", req.Service, "follow", req.Follow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Clients send nothing after the request, so a read only returns once
+	// they hang up.
+	go func() {
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+
+	err := s.ps.Logs(ctx, req, func(lines []string) error {
+		var frame []byte
+		for _, line := range lines {
+			if len(frame) > 0 && len(frame)+len(line) >= maxLogFrame {
+				if err := writePacket(conn, TEXTEncoding, TypeLogs, frame); err != nil {
+					return err
+				}
+				frame = nil
+			}
+			frame = append(frame, line...)
+			frame = append(frame, '\n')
+		}
+		return writePacket(conn, TEXTEncoding, TypeLogs, frame)
+	})
+	if err == nil || ctx.Err() != nil {
+		return nil
+	}
+	return writeLogsError(conn, err)
+}
+
+func writeLogsError(conn net.Conn, err error) error {
+	response, jsonErr := json.Marshal(models.Response[any]{RequestStatus: 0, Msg: err.Error()})
+	if jsonErr != nil {
+		return jsonErr
 	}
+	return writePacket(conn, JSONEncoding, TypeLogs, response)
 }
 
-func (s *SPMPServer) handleStart(pkt *Packet) ([]byte, string, error) {
+func (s *SPMPServer) handleStart(log logger.Logger, pkt *Packet) ([]byte, string, error) {
 	serviceName := string(pkt.Payload)
-	_, exists := s.cfg.ServiceMap[serviceName]
+	if serviceName == AllServices {
+		results, err := s.ps.StartServices(config.UnscheduledNames(s.ps.Config().ServiceMap))
+		return bulkResponse(log, "Started", results, err)
+	}
+	_, exists := s.ps.Config().ServiceMap[serviceName]
 	if !exists {
-		e := fmt.Sprintf("'%s': service name doesn't exist", serviceName)
-		return []byte(e), TEXTEncoding, nil
+		return errorResponse(log, fmt.Errorf("'%s': service name doesn't exist", serviceName))
+	}
+	log = log.With("service", serviceName)
+	message, err := s.ps.StartProcess(serviceName)
+	if err != nil {
+		log.Warn("Failed to start service", "err", err)
+		return errorResponse(log, err)
 	}
-	message := s.ps.StartProcess(serviceName)
+	log.Info("Started service", "result", message)
 	return []byte(message), TEXTEncoding, nil
 }
 
-func (s *SPMPServer) handleStop(pkt *Packet) ([]byte, string, error) {
+func (s *SPMPServer) handleStop(log logger.Logger, pkt *Packet) ([]byte, string, error) {
 	serviceName := string(pkt.Payload)
-	_, exists := s.cfg.ServiceMap[serviceName]
+	if serviceName == AllServices {
+		results, err := s.ps.StopServices(config.ServiceNames(s.ps.Config().ServiceMap))
+		return bulkResponse(log, "Stopped", results, err)
+	}
+	_, exists := s.ps.Config().ServiceMap[serviceName]
 	if !exists {
-		e := fmt.Sprintf("'%s': service name doesn't exist", serviceName)
-		return []byte(e), TEXTEncoding, nil
+		return errorResponse(log, fmt.Errorf("'%s': service name doesn't exist", serviceName))
 	}
 	message := s.ps.StopProcess(serviceName)
+	log.Info("Stopped service", "service", serviceName, "result", message)
 	return []byte(message), TEXTEncoding, nil
 }
 
-func (s *SPMPServer) handleList(pkt *Packet) ([]byte, string, error) {
+func (s *SPMPServer) handleList(log logger.Logger, pkt *Packet) ([]byte, string, error) {
 	payload := string(pkt.Payload)
 	plistData := s.ps.ListProcesses(payload)
 	data := models.Response[[]models.PListData]{
@@ -134,11 +248,112 @@ func (s *SPMPServer) handleList(pkt *Packet) ([]byte, string, error) {
 		Msg:           "Service list retrieved successfully",
 		Data:          plistData,
 	}
-	response, err := json.Marshal(data)
+	return jsonResponse(log, data)
+}
+
+func (s *SPMPServer) handleReload(log logger.Logger, pkt *Packet) ([]byte, string, error) {
+	restartChanged := string(pkt.Payload) == ReloadRestartChanged
+	data := models.Response[models.ReloadResult]{
+		RequestStatus: 1,
+		Msg:           "Configuration reloaded",
+	}
+	result, err := s.ps.Reload(restartChanged)
 	if err != nil {
-		e := fmt.Errorf("error in encoding json: %v", err)
-		return []byte(e.Error()), TEXTEncoding, nil
+		data.RequestStatus = 0
+		data.Msg = fmt.Sprintf("reload failed: %v", err)
+		log.Error("Failed to reload config", "err", err)
+	} else {
+		s.logger.Configure(s.ps.Config().DaemonLog)
+		log.Info("Reloaded config", "diff", result.ConfigDiff)
+	}
+	data.Data = result
+	for _, r := range result.Results {
+		if r.Status == models.ResultFailed {
+			data.RequestStatus = 0
+			log.Warn("Failed to apply config change", "service", r.Name, "result", r.Msg)
+		} else {
+			log.Info("Applied config change", "service", r.Name, "result", r.Msg)
+		}
 	}
+	return jsonResponse(log, data)
+}
 
+func (s *SPMPServer) handleDescribe(log logger.Logger, pkt *Packet) ([]byte, string, error) {
+	info, err := s.ps.Describe(string(pkt.Payload))
+	if err != nil {
+		return []byte(err.Error()), TEXTEncoding, nil
+	}
+	data := models.Response[models.ServiceInfo]{
+		RequestStatus: 1,
+		Msg:           "Service described successfully",
+		Data:          info,
+	}
+	return jsonResponse(log, data)
+}
+
+func (s *SPMPServer) handleStatus(log logger.Logger, pkt *Packet) ([]byte, string, error) {
+	status, err := s.ps.Status(string(pkt.Payload))
+	if err != nil {
+		return []byte(err.Error()), TEXTEncoding, nil
+	}
+	data := models.Response[models.ServiceStatus]{
+		RequestStatus: 1,
+		Msg:           "Service status retrieved successfully",
+		Data:          status,
+	}
+	return jsonResponse(log, data)
+}
+
+func (s *SPMPServer) handleEvents(log logger.Logger, pkt *Packet) ([]byte, string, error) {
+	data := models.Response[[]models.Event]{
+		RequestStatus: 1,
+		Msg:           "Events retrieved successfully",
+		Data:          s.ps.Events(string(pkt.Payload)),
+	}
+	return jsonResponse(log, data)
+}
+
+// bulkResponse encodes the per-service results of a `start all`/`stop all`
+// request. RequestStatus is 0 when any service failed.
+func bulkResponse(log logger.Logger, verb string, results []models.ServiceResult, err error) ([]byte, string, error) {
+	if err != nil {
+		log.Warn("Bulk request failed", "err", err)
+		return errorResponse(log, err)
+	}
+
+	succeeded := 0
+	var requestStatus uint8 = 1
+	for _, r := range results {
+		switch r.Status {
+		case models.ResultOK:
+			succeeded++
+			log.Info(verb+" service", "service", r.Name, "result", r.Msg)
+		case models.ResultFailed:
+			requestStatus = 0
+			log.Warn("Service failed", "service", r.Name, "result", r.Msg)
+		}
+	}
+	data := models.Response[[]models.ServiceResult]{
+		RequestStatus: requestStatus,
+		Msg:           fmt.Sprintf("%s %d of %d services", verb, succeeded, len(results)),
+		Data:          results,
+	}
+	return jsonResponse(log, data)
+}
+
+// errorResponse encodes a request that failed as a whole as a JSON response
+// with RequestStatus 0.
+func errorResponse(log logger.Logger, err error) ([]byte, string, error) {
+	return jsonResponse(log, models.Response[any]{RequestStatus: 0, Msg: err.Error()})
+}
+
+// jsonResponse encodes data as a JSON response, or as a TEXT error when it
+// can't be encoded.
+func jsonResponse(log logger.Logger, data any) ([]byte, string, error) {
+	response, err := json.Marshal(data)
+	if err != nil {
+		log.Error("Failed to encode the response", "err", err)
+		return []byte(fmt.Sprintf("error in encoding json: %v", err)), TEXTEncoding, nil
+	}
 	return response, JSONEncoding, nil
 }
@@ -21,3 +21,8 @@ func Green(w int, s string) string {
     padded := fmt.Sprintf("%-*s ", w, s)
     return "\033[32m" + padded + "\033[0m"
 }
+
+func Yellow(w int, s string) string {
+    padded := fmt.Sprintf("%-*s ", w, s)
+    return "\033[33m" + padded + "\033[0m"
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+const (
+	followInterval = 250 * time.Millisecond
+	maxLogLine     = 1024 * 1024
+)
+
+// LogSource is where the lines StreamLogs copies come from.
+type LogSource struct {
+	Service string
+	Stream  string
+	Pid     int
+}
+
+// logRecord is a line in the JSON format.
+type logRecord struct {
+	TS      time.Time       `json:"ts"`
+	Service string          `json:"service"`
+	Stream  string          `json:"stream"`
+	Pid     int             `json:"pid"`
+	Msg     json.RawMessage `json:"msg"`
+}
+
+// FormatLogLine turns a line of service output into a log line:
+//
+//	<RFC3339 time with nanoseconds> [<stream>][<service>] <text>
+//
+// or, in the JSON format, an object with the same parts and the pid. A line
+// that already is a JSON object is kept as such in msg.
+func FormatLogLine(t time.Time, src LogSource, format, line string) string {
+	if format != models.LogFormatJSON {
+		return fmt.Sprintf("%s [%s][%s] %s\n", t.Format(time.RFC3339Nano), src.Stream, src.Service, line)
+	}
+
+	record := logRecord{TS: t, Service: src.Service, Stream: src.Stream, Pid: src.Pid}
+	var compact bytes.Buffer
+	if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "{") && json.Compact(&compact, []byte(trimmed)) == nil {
+		record.Msg = compact.Bytes()
+	} else {
+		record.Msg, _ = json.Marshal(line)
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Sprintf("%s [%s][%s] %s\n", t.Format(time.RFC3339Nano), src.Stream, src.Service, line)
+	}
+	return string(b) + "\n"
+}
+
+// LogLine is a line of a service log in either format. Lines written before
+// they carried a time have none.
+type LogLine struct {
+	Time    time.Time
+	Stream  string
+	Service string
+	Pid     int
+	Msg     string
+}
+
+// ParseLogLine splits a service log line into its parts. ok is false for
+// lines in neither format. The Msg of a JSON line whose msg is an object is
+// that object.
+func ParseLogLine(line string) (l LogLine, ok bool) {
+	if strings.HasPrefix(line, "{") {
+		var record logRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil || record.Stream == "" {
+			return LogLine{Msg: line}, false
+		}
+		l = LogLine{Time: record.TS, Stream: record.Stream, Service: record.Service, Pid: record.Pid, Msg: string(record.Msg)}
+		var msg string
+		if json.Unmarshal(record.Msg, &msg) == nil {
+			l.Msg = msg
+		}
+		return l, true
+	}
+
+	if ts, rest, found := strings.Cut(line, " "); found && !strings.HasPrefix(line, "[") {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return LogLine{Msg: line}, false
+		}
+		l.Time = t
+		line = rest
+	}
+	var found bool
+	if l.Stream, line, found = cutBracket(line); !found {
+		return LogLine{Time: l.Time, Msg: line}, false
+	}
+	if l.Service, line, found = cutBracket(line); !found {
+		return LogLine{Time: l.Time, Msg: line}, false
+	}
+	l.Msg = strings.TrimPrefix(line, " ")
+	return l, true
+}
+
+func cutBracket(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return "", s, false
+	}
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return "", s, false
+	}
+	return s[1:end], s[end+1:], true
+}
+
+// TailLines returns up to the last n lines of r that keep accepts, or all of
+// them when n is negative.
+func TailLines(r io.Reader, n int, keep func(string) bool) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLine)
+	for scanner.Scan() {
+		if n == 0 {
+			continue
+		}
+		line := scanner.Text()
+		if !keep(line) {
+			continue
+		}
+		lines = append(lines, line)
+		if n > 0 && len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// FollowFile passes the lines appended to the file at path from offset on to
+// emit as they come in, until ctx is done or emit fails. The file doesn't
+// have to exist yet. A truncated file is followed again from the start, and
+// when path is replaced by a new file the old one is read to the end before
+// switching over.
+func FollowFile(ctx context.Context, path string, offset int64, emit func([]string) error) error {
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	var partial string
+	readNew := func() error {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		offset += int64(len(b))
+		data := partial + string(b)
+		end := strings.LastIndexByte(data, '\n')
+		if end < 0 {
+			partial = data
+			return nil
+		}
+		partial = data[end+1:]
+		return emit(strings.Split(data[:end], "\n"))
+	}
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		if f == nil {
+			if opened, err := os.Open(path); err == nil {
+				f = opened
+				if _, err := f.Seek(offset, io.SeekStart); err != nil {
+					return err
+				}
+			}
+		}
+		if f != nil {
+			if err := readNew(); err != nil {
+				return err
+			}
+			current, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			latest, err := os.Stat(path)
+			switch {
+			case err != nil || !os.SameFile(current, latest):
+				if err := readNew(); err != nil {
+					return err
+				}
+				f.Close()
+				f = nil
+				offset, partial = 0, ""
+			case latest.Size() < offset:
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				offset, partial = 0, ""
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Arihantawasthi/sage.git/internal/models"
+)
+
+func TestLogLineRoundTrip(t *testing.T) {
+	ts := time.Date(2026, 10, 18, 12, 30, 45, 123456789, time.UTC)
+	src := LogSource{Service: "web", Stream: models.StreamStderr, Pid: 42}
+	tests := []struct {
+		name   string
+		format string
+		line   string
+		want   string
+		pid    int
+	}{
+		{name: "text", format: models.LogFormatText, line: "listening on :8080", want: "listening on :8080"},
+		{name: "text with brackets", format: models.LogFormatText, line: "[warn][db] slow query", want: "[warn][db] slow query"},
+		{name: "text indented", format: models.LogFormatText, line: "  at main.go:12", want: "  at main.go:12"},
+		{name: "text empty", format: models.LogFormatText, line: "", want: ""},
+		{name: "default format", format: "", line: "hello", want: "hello"},
+		{name: "json string", format: models.LogFormatJSON, line: `say "hi" \ bye`, want: `say "hi" \ bye`, pid: 42},
+		{name: "json object", format: models.LogFormatJSON, line: `{"level": "info", "n": 1}`, want: `{"level":"info","n":1}`, pid: 42},
+		{name: "json object with spaces", format: models.LogFormatJSON, line: `  {"a": [1, 2]}  `, want: `{"a":[1,2]}`, pid: 42},
+		{name: "json broken object", format: models.LogFormatJSON, line: `{"a": `, want: `{"a": `, pid: 42},
+		{name: "json array", format: models.LogFormatJSON, line: `[1, 2]`, want: `[1, 2]`, pid: 42},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatted := FormatLogLine(ts, src, tt.format, tt.line)
+			if formatted[len(formatted)-1] != '\n' {
+				t.Fatalf("FormatLogLine() = %q, want a trailing newline", formatted)
+			}
+			got, ok := ParseLogLine(formatted[:len(formatted)-1])
+			if !ok {
+				t.Fatalf("ParseLogLine(%q) failed", formatted)
+			}
+			want := LogLine{Time: ts, Stream: src.Stream, Service: src.Service, Pid: tt.pid, Msg: tt.want}
+			if !got.Time.Equal(want.Time) || got.Stream != want.Stream || got.Service != want.Service || got.Pid != want.Pid || got.Msg != want.Msg {
+				t.Errorf("ParseLogLine(%q) = %+v, want %+v", formatted, got, want)
+			}
+		})
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	ts := time.Date(2026, 10, 18, 12, 30, 45, 0, time.UTC)
+	tests := []struct {
+		name string
+		line string
+		want LogLine
+		ok   bool
+	}{
+		{
+			name: "text",
+			line: "2026-10-18T12:30:45Z [stdout][web] ready",
+			want: LogLine{Time: ts, Stream: "stdout", Service: "web", Msg: "ready"},
+			ok:   true,
+		},
+		{
+			name: "text with an offset",
+			line: "2026-10-18T14:30:45+02:00 [stdout][web] ready",
+			want: LogLine{Time: ts, Stream: "stdout", Service: "web", Msg: "ready"},
+			ok:   true,
+		},
+		{
+			name: "without a time",
+			line: "[stderr][web] old line",
+			want: LogLine{Stream: "stderr", Service: "web", Msg: "old line"},
+			ok:   true,
+		},
+		{
+			name: "json",
+			line: `{"ts":"2026-10-18T12:30:45Z","service":"web","stream":"stdout","pid":7,"msg":"ready"}`,
+			want: LogLine{Time: ts, Stream: "stdout", Service: "web", Pid: 7, Msg: "ready"},
+			ok:   true,
+		},
+		{
+			name: "plain text",
+			line: "just some text",
+			want: LogLine{Msg: "just some text"},
+		},
+		{
+			name: "bad time",
+			line: "yesterday [stdout][web] ready",
+			want: LogLine{Msg: "yesterday [stdout][web] ready"},
+		},
+		{
+			name: "no service",
+			line: "2026-10-18T12:30:45Z [stdout] ready",
+			want: LogLine{Time: ts, Msg: " ready"},
+		},
+		{
+			name: "no streams",
+			line: "2026-10-18T12:30:45Z ready",
+			want: LogLine{Time: ts, Msg: "ready"},
+		},
+		{
+			name: "unclosed bracket",
+			line: "[stdout",
+			want: LogLine{Msg: "[stdout"},
+		},
+		{
+			name: "json without a stream",
+			line: `{"level":"info"}`,
+			want: LogLine{Msg: `{"level":"info"}`},
+		},
+		{
+			name: "broken json",
+			line: `{"ts":`,
+			want: LogLine{Msg: `{"ts":`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseLogLine(tt.line)
+			if ok != tt.ok || !got.Time.Equal(tt.want.Time) || got.Stream != tt.want.Stream || got.Service != tt.want.Service || got.Pid != tt.want.Pid || got.Msg != tt.want.Msg {
+				t.Errorf("ParseLogLine(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,409 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotateOptions decide when a RotatingWriter starts a new file and which of
+// the old ones it keeps. Zero values mean no limit.
+type RotateOptions struct {
+	// MaxSize rotates the file before a write would take it past this many
+	// bytes.
+	MaxSize int64
+	// Daily rotates the file at the first write of a new day.
+	Daily      bool
+	MaxBackups int
+	MaxAge     time.Duration
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// RotatingWriter appends to a file and rotates it according to its options.
+// Rotated files are numbered, <path>.1 being the newest, and get a .gz
+// suffix when compressed. Writes are serialized, so writers sharing it never
+// interleave within a write, and no write is lost to a rotation.
+type RotatingWriter struct {
+	mu     sync.Mutex
+	path   string
+	opts   RotateOptions
+	file   *os.File
+	size   int64
+	opened time.Time
+	closed bool
+	// onError gets the errors that don't fail a write, see SetErrorHandler.
+	onError func(error)
+	// compressing are the backups being gzipped in the background.
+	compressing  []*compression
+	compressions sync.WaitGroup
+}
+
+// compression is a backup being gzipped in the background. num follows the
+// backup as rotations shift it, gone is set once it has been removed.
+type compression struct {
+	num  int
+	gone bool
+}
+
+// compressingPattern names the files compressions write to until they are
+// done, which backups ignores.
+const compressingPattern = ".compressing-*"
+
+func NewRotatingWriter(path string, opts RotateOptions) (*RotatingWriter, error) {
+	w := &RotatingWriter{path: path, opts: opts}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	// Left behind by compressions that never finished.
+	if stale, err := filepath.Glob(path + compressingPattern); err == nil {
+		for _, f := range stale {
+			os.Remove(f)
+		}
+	}
+	return w, nil
+}
+
+func (w *RotatingWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	w.opened = time.Now()
+	// A file that already has something in it was started when it was
+	// last written to at the latest.
+	if w.size > 0 {
+		w.opened = info.ModTime()
+	}
+	return nil
+}
+
+// SetOptions changes the options from the next write on.
+func (w *RotatingWriter) SetOptions(opts RotateOptions) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.opts = opts
+}
+
+// SetErrorHandler makes fn get the errors rotating and compressing files run
+// into, which don't fail the write that triggered them. fn is called while
+// the writer is locked and must not write to it. Without a handler they go
+// to stderr.
+func (w *RotatingWriter) SetErrorHandler(fn func(error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onError = fn
+}
+
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.ensureOpen(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	tooBig := w.opts.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.opts.MaxSize
+	newDay := w.opts.Daily && !sameDay(w.opened, now)
+	if tooBig || newDay {
+		if err := w.rotate(); err != nil {
+			// Keep writing to the current file rather than dropping lines.
+			w.reportError(fmt.Errorf("failed to rotate %s: %w", w.path, err))
+		}
+		if err := w.ensureOpen(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate starts a new file right away, unless the current one is empty.
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.ensureOpen(); err != nil {
+		return err
+	}
+	if w.size == 0 {
+		return nil
+	}
+	return w.rotate()
+}
+
+// Truncate empties the current file.
+func (w *RotatingWriter) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.ensureOpen(); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	w.size = 0
+	w.opened = time.Now()
+	return nil
+}
+
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// reportError passes err on to the error handler. The caller must hold w.mu.
+func (w *RotatingWriter) reportError(err error) {
+	if w.onError != nil {
+		w.onError(err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+}
+
+// ensureOpen reopens the file after a rotation failed to. The caller must
+// hold w.mu.
+func (w *RotatingWriter) ensureOpen() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.file == nil {
+		return w.open()
+	}
+	return nil
+}
+
+// rotate moves the current file to <path>.1, shifting the older backups up,
+// and opens a new one. Backups are compressed in the background, so that a
+// large file doesn't hold up writes. The caller must hold w.mu.
+func (w *RotatingWriter) rotate() error {
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+	// Shift from the oldest down so no backup overwrites another.
+	for i := len(backups) - 1; i >= 0; i-- {
+		b := backups[i]
+		if w.opts.MaxBackups > 0 && b.num >= w.opts.MaxBackups {
+			os.Remove(b.path)
+			w.moved(b, 0)
+			continue
+		}
+		if err := os.Rename(b.path, w.backupPath(b.num+1, b.compressed)); err != nil {
+			return err
+		}
+		w.moved(b, b.num+1)
+	}
+
+	if err := os.Rename(w.path, w.backupPath(1, false)); err != nil {
+		return err
+	}
+	w.file.Close()
+	if err := w.open(); err != nil {
+		w.file = nil
+		return err
+	}
+
+	if w.opts.Compress {
+		w.compressBackups()
+	}
+	if w.opts.MaxAge > 0 {
+		w.removeExpired()
+	}
+	return nil
+}
+
+// moved records that backup b is now number num, or gone for 0, for the
+// compression of it that may be going on. The caller must hold w.mu.
+func (w *RotatingWriter) moved(b backup, num int) {
+	if b.compressed {
+		return
+	}
+	for _, c := range w.compressing {
+		if c.num == b.num && !c.gone {
+			if num == 0 {
+				c.gone = true
+			} else {
+				c.num = num
+			}
+			return
+		}
+	}
+}
+
+// compressBackups starts compressing every uncompressed backup that isn't
+// being compressed yet. The caller must hold w.mu.
+func (w *RotatingWriter) compressBackups() {
+	backups, err := w.backups()
+	if err != nil {
+		return
+	}
+	for _, b := range backups {
+		if b.compressed || w.isCompressing(b.num) {
+			continue
+		}
+		c := &compression{num: b.num}
+		w.compressing = append(w.compressing, c)
+		w.compressions.Add(1)
+		go w.compress(c)
+	}
+}
+
+func (w *RotatingWriter) isCompressing(num int) bool {
+	for _, c := range w.compressing {
+		if c.num == num && !c.gone {
+			return true
+		}
+	}
+	return false
+}
+
+// compress gzips the backup into a temporary file and, unless the backup
+// was removed in the meantime, replaces it with that under its current
+// number, keeping its modification time for MaxAge.
+func (w *RotatingWriter) compress(c *compression) {
+	defer w.compressions.Done()
+	w.mu.Lock()
+	src, err := os.Open(w.backupPath(c.num, false))
+	w.mu.Unlock()
+	var tmp string
+	if err == nil {
+		tmp, err = gzipFile(src, w.path)
+		src.Close()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.compressing = slices.DeleteFunc(w.compressing, func(other *compression) bool { return other == c })
+	if c.gone {
+		if tmp != "" {
+			os.Remove(tmp)
+		}
+		return
+	}
+	if err != nil {
+		w.reportError(fmt.Errorf("failed to compress %s: %w", w.backupPath(c.num, false), err))
+		return
+	}
+	if err := os.Rename(tmp, w.backupPath(c.num, true)); err != nil {
+		os.Remove(tmp)
+		w.reportError(fmt.Errorf("failed to compress %s: %w", w.backupPath(c.num, false), err))
+		return
+	}
+	os.Remove(w.backupPath(c.num, false))
+}
+
+type backup struct {
+	path       string
+	num        int
+	compressed bool
+}
+
+// backups lists the rotated files, newest first.
+func (w *RotatingWriter) backups() ([]backup, error) {
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []backup
+	for _, path := range matches {
+		suffix := strings.TrimPrefix(path, w.path+".")
+		numPart, compressed := strings.CutSuffix(suffix, ".gz")
+		num, err := strconv.Atoi(numPart)
+		if err != nil || num < 1 {
+			continue
+		}
+		backups = append(backups, backup{path: path, num: num, compressed: compressed})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].num < backups[j].num })
+	return backups, nil
+}
+
+func (w *RotatingWriter) backupPath(num int, compressed bool) string {
+	path := fmt.Sprintf("%s.%d", w.path, num)
+	if compressed {
+		path += ".gz"
+	}
+	return path
+}
+
+// removeExpired deletes the backups last written to more than MaxAge ago.
+func (w *RotatingWriter) removeExpired() {
+	backups, err := w.backups()
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-w.opts.MaxAge)
+	for _, b := range backups {
+		if info, err := os.Stat(b.path); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(b.path)
+			w.moved(b, 0)
+		}
+	}
+}
+
+// gzipFile writes a gzipped copy of src next to path under a temporary name,
+// with the modification time of src, and returns that name.
+func gzipFile(src *os.File, path string) (string, error) {
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+	dst, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+compressingPattern)
+	if err != nil {
+		return "", err
+	}
+	if err := dst.Chmod(0644); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	os.Chtimes(dst.Name(), info.ModTime(), info.ModTime())
+	return dst.Name(), nil
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
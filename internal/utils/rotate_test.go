@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// files returns the names in dir and what each holds, gunzipped.
+func files(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]string)
+	for _, e := range entries {
+		f, err := os.Open(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if filepath.Ext(e.Name()) == ".gz" {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatalf("%s: %v", e.Name(), err)
+			}
+			r = zr
+		}
+		b, err := io.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", e.Name(), err)
+		}
+		out[e.Name()] = string(b)
+	}
+	return out
+}
+
+func TestRotatingWriter(t *testing.T) {
+	lines := []string{"aaaaaaa\n", "bbbbbbb\n", "ccccccc\n", "ddddddd\n", "eeeeeee\n"}
+	tests := []struct {
+		name string
+		opts RotateOptions
+		// wait lets every compression finish after each write rather than
+		// only at the end, when rotations find the newest backup still
+		// being compressed.
+		wait bool
+		want map[string]string
+	}{
+		{
+			name: "no limit",
+			opts: RotateOptions{},
+			want: map[string]string{"a.log": "aaaaaaa\nbbbbbbb\nccccccc\nddddddd\neeeeeee\n"},
+		},
+		{
+			name: "shift",
+			opts: RotateOptions{MaxSize: 10},
+			want: map[string]string{
+				"a.log":   "eeeeeee\n",
+				"a.log.1": "ddddddd\n",
+				"a.log.2": "ccccccc\n",
+				"a.log.3": "bbbbbbb\n",
+				"a.log.4": "aaaaaaa\n",
+			},
+		},
+		{
+			name: "max backups",
+			opts: RotateOptions{MaxSize: 10, MaxBackups: 2},
+			want: map[string]string{
+				"a.log":   "eeeeeee\n",
+				"a.log.1": "ddddddd\n",
+				"a.log.2": "ccccccc\n",
+			},
+		},
+		{
+			name: "two lines a file",
+			opts: RotateOptions{MaxSize: 16},
+			want: map[string]string{
+				"a.log":   "eeeeeee\n",
+				"a.log.1": "ccccccc\nddddddd\n",
+				"a.log.2": "aaaaaaa\nbbbbbbb\n",
+			},
+		},
+		{
+			name: "compress",
+			opts: RotateOptions{MaxSize: 10, Compress: true},
+			wait: true,
+			want: map[string]string{
+				"a.log":      "eeeeeee\n",
+				"a.log.1.gz": "ddddddd\n",
+				"a.log.2.gz": "ccccccc\n",
+				"a.log.3.gz": "bbbbbbb\n",
+				"a.log.4.gz": "aaaaaaa\n",
+			},
+		},
+		{
+			name: "compress while compressing",
+			opts: RotateOptions{MaxSize: 10, Compress: true},
+			want: map[string]string{
+				"a.log":      "eeeeeee\n",
+				"a.log.1.gz": "ddddddd\n",
+				"a.log.2.gz": "ccccccc\n",
+				"a.log.3.gz": "bbbbbbb\n",
+				"a.log.4.gz": "aaaaaaa\n",
+			},
+		},
+		{
+			name: "compress with max backups",
+			opts: RotateOptions{MaxSize: 10, MaxBackups: 2, Compress: true},
+			want: map[string]string{
+				"a.log":      "eeeeeee\n",
+				"a.log.1.gz": "ddddddd\n",
+				"a.log.2.gz": "ccccccc\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := NewRotatingWriter(filepath.Join(dir, "a.log"), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.SetErrorHandler(func(err error) { t.Error(err) })
+			for _, line := range lines {
+				if _, err := w.Write([]byte(line)); err != nil {
+					t.Fatal(err)
+				}
+				if tt.wait {
+					w.compressions.Wait()
+				}
+			}
+			w.compressions.Wait()
+			w.Close()
+			if got := files(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotatingWriterMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	old := time.Now().Add(-48 * time.Hour)
+	for name, content := range map[string]string{"a.log.1": "recent\n", "a.log.2.gz": "", "a.log.3": "old\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a.log.2.gz", "a.log.3"} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := NewRotatingWriter(path, RotateOptions{MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("current\n"))
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	want := map[string]string{"a.log": "", "a.log.1": "current\n", "a.log.2": "recent\n"}
+	if got := files(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
+
+func TestRotatingWriterDaily(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	if err := os.WriteFile(path, []byte("yesterday\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().AddDate(0, 0, -1)
+	if err := os.Chtimes(path, yesterday, yesterday); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewRotatingWriter(path, RotateOptions{Daily: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("today\n"))
+	w.Write([]byte("still today\n"))
+	w.Close()
+	want := map[string]string{"a.log": "today\nstill today\n", "a.log.1": "yesterday\n"}
+	if got := files(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
+
+func TestRotatingWriterRotateTruncate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	// Left behind by a compression that never finished.
+	if err := os.WriteFile(path+".compressing-123", []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewRotatingWriter(path, RotateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := files(t, dir), map[string]string{"a.log": ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after rotating an empty file: files = %q, want %q", got, want)
+	}
+
+	w.Write([]byte("first\n"))
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("second\n"))
+	if err := w.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("third\n"))
+	want := map[string]string{"a.log": "third\n", "a.log.1": "first\n"}
+	if got := files(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
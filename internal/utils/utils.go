@@ -5,49 +5,62 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Arihantawasthi/sage.git/internal/models"
 )
 
-func CreateServiceLogDir() (string, error) {
-    homeDir, err := os.UserHomeDir()
-    if err != nil {
-        return "", err
-    }
-    sageDir := fmt.Sprintf("%s/.sage/logs", homeDir)
-
-    err = os.MkdirAll(sageDir, 0755)
-    if err != nil {
-        return "", err
-    }
-    return sageDir, nil
+func CreateServiceLogDir(logDir string) error {
+    return os.MkdirAll(logDir, 0755)
 }
 
-func StreamLogs(pipe io.ReadCloser, prefix, serviceLogPath string) {
+// StreamLogs copies the lines read from pipe to out in the given format,
+// each on a single Write so that streams sharing out don't interleave, until
+// pipe is closed or out fails.
+func StreamLogs(pipe io.ReadCloser, src LogSource, format string, out io.Writer) error {
+    defer pipe.Close()
     scanner := bufio.NewScanner(pipe)
-    lFile, err := os.OpenFile(serviceLogPath, os.O_APPEND|os.O_CREATE, 0644)
-    if err != nil {
-        return
-    }
-    defer lFile.Close()
+    scanner.Buffer(make([]byte, 64*1024), maxLogLine)
     for scanner.Scan() {
-        line := scanner.Text()
-        logLine := fmt.Sprintf("%s %s\n", prefix, line)
+        logLine := FormatLogLine(time.Now(), src, format, scanner.Text())
 
-        if _, err := lFile.WriteString(logLine); err != nil {
-            fmt.Printf("Failed to write log for %s: %v\n", serviceLogPath, err)
-            return
+        if _, err := io.WriteString(out, logLine); err != nil {
+            return err
         }
     }
+    return scanner.Err()
+}
+
+// TailFile returns up to the last n lines of the file at path.
+func TailFile(path string, n int) ([]string, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
 
-    if err := scanner.Err(); err != nil {
-        fmt.Printf("%s error: %v\n", prefix, err)
+    var lines []string
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        lines = append(lines, scanner.Text())
+        if len(lines) > n {
+            lines = lines[1:]
+        }
     }
+    return lines, scanner.Err()
 }
 
 func PrintTable(data []models.PListData) {
-    headers := []string{"SNo.", "PID", "P_NAME", "NAME", "CMD", "STATUS", "UP TIME", "CPU%", "MEM%"}
+    headers := []string{"SNo.", "PID", "P_NAME", "NAME", "CMD", "STATUS", "UP TIME", "CPU%", "MEM%", "HEALTH", "RESTARTS", "LAST EXIT"}
+    // Scheduled services get columns for their last and next run.
+    fixed := len(headers)
+    hasJobs := slices.ContainsFunc(data, func(d models.PListData) bool { return d.Job != nil })
+    if hasJobs {
+        headers = append(headers, "LAST RUN", "DURATION", "EXIT", "NEXT RUN")
+    }
     widths := make([]int, len(headers))
 
     for i, h := range headers {
@@ -65,6 +78,14 @@ func PrintTable(data []models.PListData) {
         widths[6] = max(widths[6], len(d.UpTime) + padding)
         widths[7] = max(widths[7], len(fmt.Sprintf("%0.02f", d.CPUPercent)) + padding)
         widths[8] = max(widths[8], len(fmt.Sprintf("%0.02f", d.MemPrecent)) + padding)
+        widths[9] = max(widths[9], len(healthLabel(d.Health)) + padding)
+        widths[10] = max(widths[10], len(strconv.Itoa(d.Restarts)) + padding)
+        widths[11] = max(widths[11], len(d.LastExit) + padding)
+        if hasJobs {
+            for i, cell := range jobCells(d.Job) {
+                widths[fixed+i] = max(widths[fixed+i], len(cell) + padding)
+            }
+        }
     }
     printBorders(widths, headers)
 
@@ -80,19 +101,111 @@ func PrintTable(data []models.PListData) {
         fmt.Printf("| %-*s ", widths[2], d.PName)
         fmt.Printf("| %-*s ", widths[3], d.Name)
         fmt.Printf("| %-*s ", widths[4], d.Cmd)
-        if d.Status == "online" {
+        if d.Status == models.StatusOnline {
             fmt.Printf("| %s", Green(widths[5], d.Status))
+        } else if d.Status == models.StatusRestarting {
+            fmt.Printf("| %s", Yellow(widths[5], d.Status))
         } else {
             fmt.Printf("| %s", Red(widths[5], d.Status))
         }
         fmt.Printf("| %-*s ", widths[6], d.UpTime)
         fmt.Printf("| %-*.2f ", widths[7], d.CPUPercent)
         fmt.Printf("| %-*.2f ", widths[8], d.MemPrecent)
+        switch d.Health {
+        case models.HealthHealthy:
+            fmt.Printf("| %s", Green(widths[9], d.Health))
+        case models.HealthUnhealthy:
+            fmt.Printf("| %s", Red(widths[9], d.Health))
+        default:
+            fmt.Printf("| %-*s ", widths[9], healthLabel(d.Health))
+        }
+        fmt.Printf("| %-*d ", widths[10], d.Restarts)
+        fmt.Printf("| %-*s ", widths[11], d.LastExit)
+        if hasJobs {
+            for i, cell := range jobCells(d.Job) {
+                fmt.Printf("| %-*s ", widths[fixed+i], cell)
+            }
+        }
         fmt.Println()
     }
     printBorders(widths, headers)
 }
 
+func PrintResults(results []models.ServiceResult) {
+    headers := []string{"NAME", "RESULT", "MESSAGE"}
+    widths := make([]int, len(headers))
+    for i, h := range headers {
+        widths[i] = len(h)
+    }
+
+    padding := 6
+    for _, r := range results {
+        widths[0] = max(widths[0], len(r.Name) + padding)
+        widths[1] = max(widths[1], len(r.Status) + padding)
+        for _, line := range strings.Split(r.Msg, "\n") {
+            widths[2] = max(widths[2], len(line) + padding)
+        }
+    }
+    printBorders(widths, headers)
+
+    for i, h := range headers {
+        fmt.Printf("| %s", CyanBold(widths[i], h))
+    }
+    fmt.Println()
+
+    printBorders(widths, headers)
+    for _, r := range results {
+        for i, line := range strings.Split(r.Msg, "\n") {
+            if i > 0 {
+                fmt.Printf("| %-*s | %-*s ", widths[0], "", widths[1], "")
+            } else {
+                fmt.Printf("| %-*s ", widths[0], r.Name)
+                switch r.Status {
+                case models.ResultOK:
+                    fmt.Printf("| %s", Green(widths[1], r.Status))
+                case models.ResultFailed:
+                    fmt.Printf("| %s", Red(widths[1], r.Status))
+                default:
+                    fmt.Printf("| %s", Yellow(widths[1], r.Status))
+                }
+            }
+            fmt.Printf("| %-*s ", widths[2], line)
+            fmt.Println()
+        }
+    }
+    printBorders(widths, headers)
+}
+
+// jobCells are the last run, its duration and exit code, and the next run of
+// a scheduled service.
+func jobCells(job *models.JobStatus) []string {
+    cells := []string{"-", "-", "-", "-"}
+    if job == nil {
+        return cells
+    }
+    if !job.LastRun.IsZero() {
+        cells[0] = job.LastRun.Local().Format(time.DateTime)
+        cells[1] = job.Duration.String()
+    }
+    if job.ExitCode != nil {
+        cells[2] = strconv.Itoa(*job.ExitCode)
+    }
+    if !job.NextRun.IsZero() {
+        cells[3] = job.NextRun.Local().Format(time.DateTime)
+    }
+    if job.Queued {
+        cells[3] += " (queued)"
+    }
+    return cells
+}
+
+func healthLabel(health string) string {
+    if health == "" {
+        return "-"
+    }
+    return health
+}
+
 func printBorders(widths []int, headers []string) {
     for i := range headers {
         for w := 0; w < widths[i]+3; w++ {